@@ -0,0 +1,48 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/cipher"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+var _ cipher.BlockMode = (*blockMode)(nil)
+
+type blockMode struct {
+	c *Cipher
+}
+
+// NewBlockMode returns a cipher.BlockMode that encrypts/decrypts whole
+// 64 byte blocks by XORing them with the key stream of c, for use with code
+// written against the block mode interface.  The key stream position is
+// shared with c.  CryptBlocks panics if the length of src is not a multiple
+// of BlockSize.
+func NewBlockMode(c *Cipher) cipher.BlockMode {
+	return &blockMode{c: c}
+}
+
+func (m *blockMode) BlockSize() int {
+	return api.BlockSize
+}
+
+func (m *blockMode) CryptBlocks(dst, src []byte) {
+	if len(src)%api.BlockSize != 0 {
+		panic("chacha20: input not full blocks")
+	}
+	m.c.XORKeyStream(dst, src)
+}
@@ -0,0 +1,68 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestBlockMode(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		src, dst, expected [5 * api.BlockSize]byte
+	)
+	for _, b := range [][]byte{key[:], nonce[:], src[:]} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.XORKeyStream(expected[:], src[:])
+
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	bm := NewBlockMode(c)
+	require.Equal(BlockSize, bm.BlockSize(), "BlockSize")
+	bm.CryptBlocks(dst[:0], src[:0])
+	bm.CryptBlocks(dst[:api.BlockSize], src[:api.BlockSize])
+	bm.CryptBlocks(dst[api.BlockSize:], src[api.BlockSize:])
+	require.Equal(expected, dst, "CryptBlocks - output")
+
+	// Decryption is the same operation.
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+	bm.CryptBlocks(dst[:], dst[:])
+	require.Equal(src, dst, "CryptBlocks - round trip")
+
+	for _, n := range []int{1, api.BlockSize - 1, api.BlockSize + 1, 2*api.BlockSize + 13} {
+		require.Panicsf(func() {
+			bm.CryptBlocks(dst[:n], src[:n])
+		}, "CryptBlocks - misaligned: %d", n)
+	}
+	require.Panics(func() {
+		bm.CryptBlocks(dst[:api.BlockSize], src[:])
+	}, "CryptBlocks - short dst")
+}
@@ -18,9 +18,12 @@ package chacha20 // import "github.com/fengxuway/chacha20"
 
 import (
 	"crypto/cipher"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
+	"io"
 	"math"
+	"strconv"
 
 	"github.com/fengxuway/chacha20/internal/api"
 	"github.com/fengxuway/chacha20/internal/hardware"
@@ -42,8 +45,55 @@ const (
 
 	// HNonceSize is the HChaCha20 nonce size in bytes.
 	HNonceSize = 16
+
+	// BlockSize is the ChaCha20 block size in bytes.
+	BlockSize = api.BlockSize
+)
+
+// Mode is a ChaCha20 variant.
+type Mode int
+
+const (
+	// ModeDJB is the original ChaCha20, with a 64 bit nonce and 64 bit
+	// block counter.
+	ModeDJB Mode = iota
+
+	// ModeIETF is the IETF ChaCha20, with a 96 bit nonce and 32 bit block
+	// counter.
+	ModeIETF
+
+	// ModeXChaCha20 is XChaCha20, with a 192 bit nonce and 64 bit block
+	// counter.
+	ModeXChaCha20
 )
 
+// String returns the string representation of a Mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeDJB:
+		return "ChaCha20"
+	case ModeIETF:
+		return "ChaCha20-IETF"
+	case ModeXChaCha20:
+		return "XChaCha20"
+	default:
+		return "chacha20.Mode(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+func (m Mode) nonceSize() int {
+	switch m {
+	case ModeDJB:
+		return NonceSize
+	case ModeIETF:
+		return INonceSize
+	case ModeXChaCha20:
+		return XNonceSize
+	default:
+		return 0
+	}
+}
+
 var (
 	// ErrInvalidKey is the error returned when the key is invalid.
 	ErrInvalidKey = errors.New("chacha20: key length must be KeySize bytes")
@@ -51,26 +101,56 @@ var (
 	// ErrInvalidNonce is the error returned when the nonce is invalid.
 	ErrInvalidNonce = errors.New("chacha20: nonce length must be NonceSize/INonceSize/XNonceSize bytes")
 
+	// ErrKeyNonceOverlap is the error returned when the key and nonce
+	// share memory.
+	ErrKeyNonceOverlap = errors.New("chacha20: key and nonce overlap")
+
 	// ErrInvalidCounter is the error returned when the counter is invalid.
 	ErrInvalidCounter = errors.New("chacha20: block counter is invalid (out of range)")
 
+	// ErrKeyStreamExhausted is the error returned when the operation would
+	// exceed the key stream per nonce limit.
+	ErrKeyStreamExhausted = errors.New("chacha20: will exceed key stream per nonce limit")
+
 	supportedImpls []api.Implementation
 	activeImpl     api.Implementation
 
 	_ cipher.Stream = (*Cipher)(nil)
 )
 
+// cursor is a saved key stream position.
+type cursor struct {
+	counter [2]uint32
+	buf     [api.BlockSize]byte
+	off     int
+}
+
 // Cipher is an instance of ChaCha20/XChaCha20 using a particular key and nonce.
+//
+// Key stream that is generated but not yet consumed (eg: the tail of a block
+// after a KeyStream call that is not a multiple of BlockSize) is only ever
+// held in an internal buffer of the instance, which is cleared by Reset.  Any
+// temporary copies made by methods are cleared before they return.
 type Cipher struct {
 	state [api.StateSize]uint32
 	buf   [api.BlockSize]byte
+	nonce [XNonceSize]byte
 
 	off  int
-	ietf bool
+	mode Mode
+
+	destroyed bool
+	subKeyed  bool
 }
 
 // Reset zeros the key data so that it will no longer appear in the process's
-// memory.
+// memory.  Using the instance to generate key stream after Reset, without
+// re-keying it first, will panic.
+//
+// Note: Previously a Reset instance would silently continue to generate key
+// stream derived from the all zero state.  Every method that generates or
+// repositions the key stream (eg: XORKeyStream, KeyStream, Seek, Ratchet,
+// Shuffle) now panics instead, until ReKey or SetKey is called.
 func (c *Cipher) Reset() {
 	for i := range c.state {
 		c.state[i] = 0
@@ -78,12 +158,102 @@ func (c *Cipher) Reset() {
 	for i := range c.buf {
 		c.buf[i] = 0
 	}
+	for i := range c.nonce {
+		c.nonce[i] = 0
+	}
+	c.off = api.BlockSize
+	c.destroyed = true
+	c.subKeyed = false
+}
+
+// checkDestroyed panics if the instance has been Reset.
+func (c *Cipher) checkDestroyed() {
+	if c.destroyed {
+		panic("chacha20: Cipher used after Reset without being re-keyed")
+	}
+}
+
+// Mode returns the ChaCha20 variant used by the instance.
+func (c *Cipher) Mode() Mode {
+	return c.mode
+}
+
+// NonceSize returns the size of the nonce in bytes, for the ChaCha20 variant
+// used by the instance.
+func (c *Cipher) NonceSize() int {
+	return c.mode.nonceSize()
+}
+
+// Nonce returns a copy of the nonce used by the instance.  For XChaCha20 this
+// is the full 192 bit nonce, not the derived 64 bit nonce.
+func (c *Cipher) Nonce() []byte {
+	nonce := make([]byte, c.mode.nonceSize())
+	copy(nonce, c.nonce[:])
+	return nonce
+}
+
+// Equal returns true iff c and other would produce identical key stream
+// from their current positions (same variant, key, nonce, counter, and
+// buffered key stream).  The key material and key stream are compared in
+// constant time.
+func (c *Cipher) Equal(other *Cipher) bool {
+	if c.mode != other.mode || c.off != other.off {
+		return false
+	}
+
+	var d uint32
+	for i := range c.state {
+		d |= c.state[i] ^ other.state[i]
+	}
+	for i := range c.nonce {
+		d |= uint32(c.nonce[i] ^ other.nonce[i])
+	}
+	for i := c.off; i < len(c.buf); i++ {
+		d |= uint32(c.buf[i] ^ other.buf[i])
+	}
+
+	return subtle.ConstantTimeEq(int32(d), 0) == 1
 }
 
-// Seek sets the block counter to a given offset.
+// SubStream returns a new instance producing an independent key stream for
+// the provided domain, for protocols that need multiple key streams from a
+// single key and nonce.  It will panic if the instance is in IETF mode, as
+// the 32 bit block counter leaves no room for a domain.
+//
+// The domain is placed in the upper 32 bits of the 64 bit block counter, so
+// the key stream for a domain is blocks [domain * 2^32, (domain + 1) * 2^32)
+// of the original stream.  To guarantee that domains never overlap, the
+// returned instance is an IETF mode instance (with the 32 bit block counter
+// and 256 GiB limit), with the nonce domain (little endian) || nonce.  For
+// XChaCha20, it is keyed with the HChaCha20 derived subkey and the last 8
+// bytes of the nonce, so it can not be re-created from the caller's key, and
+// Snapshot will fail.  Domain 0 overlaps with the start of the original key
+// stream, and should not be used if the original instance is also used.
+func (c *Cipher) SubStream(domain uint32) *Cipher {
+	c.checkDestroyed()
+	if c.mode == ModeIETF {
+		panic("chacha20: SubStream requires a 64 bit block counter")
+	}
+
+	sc := &Cipher{
+		state:    c.state,
+		off:      api.BlockSize,
+		mode:     ModeIETF,
+		subKeyed: c.mode == ModeXChaCha20,
+	}
+	sc.state[12] = 0
+	sc.state[13] = domain
+	api.StoreWords(sc.nonce[:INonceSize], sc.state[13:16])
+
+	return sc
+}
+
+// Seek sets the block counter to a given offset.  In IETF mode, offsets that
+// leave no usable keystream (blockCounter >= math.MaxUint32) are rejected.
 func (c *Cipher) Seek(blockCounter uint64) error {
-	if c.ietf {
-		if blockCounter > math.MaxUint32 {
+	c.checkDestroyed()
+	if c.mode == ModeIETF {
+		if blockCounter >= math.MaxUint32 {
 			return ErrInvalidCounter
 		}
 		c.state[12] = uint32(blockCounter)
@@ -95,6 +265,36 @@ func (c *Cipher) Seek(blockCounter uint64) error {
 	return nil
 }
 
+// saveCursor saves the current key stream position into cur.
+func (c *Cipher) saveCursor(cur *cursor) {
+	cur.counter[0], cur.counter[1] = c.state[12], c.state[13]
+	copy(cur.buf[:], c.buf[:])
+	cur.off = c.off
+}
+
+// restoreCursor restores the key stream position saved in cur, and clears
+// cur.
+func (c *Cipher) restoreCursor(cur *cursor) {
+	c.state[12], c.state[13] = cur.counter[0], cur.counter[1]
+	copy(c.buf[:], cur.buf[:])
+	c.off = cur.off
+	for i := range cur.buf {
+		cur.buf[i] = 0
+	}
+}
+
+// seekBytes sets the key stream position to a given byte offset.
+func (c *Cipher) seekBytes(offset uint64) error {
+	if err := c.Seek(offset / api.BlockSize); err != nil {
+		return err
+	}
+	if off := int(offset % api.BlockSize); off != 0 {
+		c.doBlocks(c.buf[:], nil, 1)
+		c.off = off
+	}
+	return nil
+}
+
 // ReKey reinitializes the ChaCha20/XChaCha20 instance with the provided key
 // and nonce.
 func (c *Cipher) ReKey(key, nonce []byte) error {
@@ -102,52 +302,72 @@ func (c *Cipher) ReKey(key, nonce []byte) error {
 	return c.doReKey(key, nonce)
 }
 
+// Ratchet replaces the key with one derived from the key stream, so that a
+// compromise of the new state does not expose key stream generated before
+// the call.  The new key is the next KeySize bytes of key stream from the
+// current position, the nonce is retained (for XChaCha20 the subkey is
+// re-derived from the new key and the full nonce), and the block counter is
+// reset to 0.  In IETF mode, ErrKeyStreamExhausted is returned if there is
+// not enough key stream left to derive the new key.
+func (c *Cipher) Ratchet() error {
+	if c.mode == ModeIETF && c.ietfRemaining() < KeySize {
+		return ErrKeyStreamExhausted
+	}
+
+	var key [KeySize]byte
+	c.KeyStream(key[:])
+	var nonce [XNonceSize]byte
+	copy(nonce[:], c.nonce[:])
+
+	c.Reset()
+	err := c.doReKey(key[:], nonce[:c.mode.nonceSize()])
+	for i := range key {
+		key[i] = 0
+	}
+
+	return err
+}
+
 func (c *Cipher) doReKey(key, nonce []byte) error {
 	if len(key) != KeySize {
 		return ErrInvalidKey
 	}
+	// Overlapping key and nonce is always a slicing mistake.  Adjacent
+	// slices of the same array are fine.
+	if anyOverlap(key, nonce) {
+		return ErrKeyNonceOverlap
+	}
 
 	var subKey []byte
+	fullNonce := nonce
 	switch len(nonce) {
-	case NonceSize, INonceSize:
+	case NonceSize:
+		c.mode = ModeDJB
+	case INonceSize:
+		c.mode = ModeIETF
 	case XNonceSize:
 		subKey = c.buf[:KeySize]
 		activeImpl.HChaCha(key, nonce, subKey)
 		key = subKey
 		nonce = nonce[16:24]
+		c.mode = ModeXChaCha20
 	default:
 		return ErrInvalidNonce
 	}
+	c.nonce = [XNonceSize]byte{}
+	copy(c.nonce[:], fullNonce)
 
-	_ = key[31] // Force bounds check elimination.
-
-	c.state[0] = api.Sigma0
-	c.state[1] = api.Sigma1
-	c.state[2] = api.Sigma2
-	c.state[3] = api.Sigma3
-	c.state[4] = binary.LittleEndian.Uint32(key[0:4])
-	c.state[5] = binary.LittleEndian.Uint32(key[4:8])
-	c.state[6] = binary.LittleEndian.Uint32(key[8:12])
-	c.state[7] = binary.LittleEndian.Uint32(key[12:16])
-	c.state[8] = binary.LittleEndian.Uint32(key[16:20])
-	c.state[9] = binary.LittleEndian.Uint32(key[20:24])
-	c.state[10] = binary.LittleEndian.Uint32(key[24:28])
-	c.state[11] = binary.LittleEndian.Uint32(key[28:32])
+	api.InitState(&c.state, key)
 	c.state[12] = 0
-	if len(nonce) == INonceSize {
-		_ = nonce[11] // Force bounds check elimination.
-		c.state[13] = binary.LittleEndian.Uint32(nonce[0:4])
-		c.state[14] = binary.LittleEndian.Uint32(nonce[4:8])
-		c.state[15] = binary.LittleEndian.Uint32(nonce[8:12])
-		c.ietf = true
+	if c.mode == ModeIETF {
+		api.LoadWords(c.state[13:16], nonce[:12])
 	} else {
-		_ = nonce[7] // Force bounds check elimination.
 		c.state[13] = 0
-		c.state[14] = binary.LittleEndian.Uint32(nonce[0:4])
-		c.state[15] = binary.LittleEndian.Uint32(nonce[4:8])
-		c.ietf = false
+		api.LoadWords(c.state[14:16], nonce[:8])
 	}
 	c.off = api.BlockSize
+	c.destroyed = false
+	c.subKeyed = false
 
 	if subKey != nil {
 		for i := range subKey {
@@ -158,8 +378,52 @@ func (c *Cipher) doReKey(key, nonce []byte) error {
 	return nil
 }
 
-// New returns a new ChaCha20/XChaCha20 instance.
+// New returns a new ChaCha20/XChaCha20 instance, with the variant selected
+// based on the length of the nonce.
 func New(key, nonce []byte) (*Cipher, error) {
+	switch len(nonce) {
+	case NonceSize:
+		return NewDJB(key, nonce)
+	case INonceSize:
+		return NewIETF(key, nonce)
+	case XNonceSize:
+		return NewX(key, nonce)
+	}
+	return newCipher(key, nonce, 0)
+}
+
+// NewDJB returns a new ChaCha20 instance with the original 64 bit nonce and
+// 64 bit block counter.
+func NewDJB(key, nonce []byte) (*Cipher, error) {
+	return newCipher(key, nonce, NonceSize)
+}
+
+// NewIETF returns a new ChaCha20 instance with the IETF 96 bit nonce and
+// 32 bit block counter.  The 32 bit block counter limits the key stream to
+// 256 GiB per nonce.
+func NewIETF(key, nonce []byte) (*Cipher, error) {
+	return newCipher(key, nonce, INonceSize)
+}
+
+// NewX returns a new XChaCha20 instance with a 192 bit nonce and 64 bit
+// block counter.  The 64 bit block counter allows for an essentially
+// unbounded (2^70 byte) key stream per nonce, and the nonce is large enough
+// to be generated randomly, making this the variant of choice for large
+// files and streams.
+func NewX(key, nonce []byte) (*Cipher, error) {
+	return newCipher(key, nonce, XNonceSize)
+}
+
+func newCipher(key, nonce []byte, nonceSize int) (*Cipher, error) {
+	// Always validate the key first, so that all of the constructors
+	// report errors consistently.
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	if nonceSize != 0 && len(nonce) != nonceSize {
+		return nil, ErrInvalidNonce
+	}
+
 	var c Cipher
 	if err := c.doReKey(key, nonce); err != nil {
 		return nil, err
@@ -168,16 +432,94 @@ func New(key, nonce []byte) (*Cipher, error) {
 	return &c, nil
 }
 
-// HChaCha is the HChaCha20 hash function used to make XChaCha.
+// HChaCha is the HChaCha20 hash function used to make XChaCha.  It will
+// panic without writing to dst if the key is not KeySize bytes, or the nonce
+// is not HNonceSize bytes.
+//
+// Deprecated: Use HChaChaErr, which returns an error instead of panicking.
 func HChaCha(key, nonce []byte, dst *[32]byte) {
+	if err := HChaChaErr(key, nonce, dst); err != nil {
+		panic(err)
+	}
+}
+
+// HChaChaErr is the HChaCha20 hash function used to make XChaCha.  If the
+// key is not KeySize bytes, or the nonce is not HNonceSize bytes, dst is
+// left untouched and ErrInvalidKey or ErrInvalidNonce is returned.
+func HChaChaErr(key, nonce []byte, dst *[32]byte) error {
+	if len(key) != KeySize {
+		return ErrInvalidKey
+	}
+	if len(nonce) != HNonceSize {
+		return ErrInvalidNonce
+	}
 	activeImpl.HChaCha(key, nonce, dst[:])
+
+	return nil
+}
+
+// AlignUp returns n rounded up to the next multiple of BlockSize.
+func AlignUp(n int) int {
+	return (n + BlockSize - 1) &^ (BlockSize - 1)
+}
+
+// XORKeyStream sets dst to the result of XORing src with the key stream
+// generated with the provided key and nonce, starting from block 0, with the
+// variant selected based on the length of the nonce.  The transient cipher
+// state is cleared before returning.
+func XORKeyStream(dst, src, key, nonce []byte) error {
+	var c Cipher
+	if err := c.doReKey(key, nonce); err != nil {
+		return err
+	}
+	c.XORKeyStream(dst, src)
+	c.Reset()
+
+	return nil
+}
+
+// XChaCha20XOR sets dst to the result of XORing src with the XChaCha20 key
+// stream generated with the provided nonce and key, starting from block 0.
+// This is equivalent to libsodium's crypto_stream_xchacha20_xor.
+func XChaCha20XOR(dst, src, nonce, key []byte) error {
+	c, err := NewX(key, nonce)
+	if err != nil {
+		return err
+	}
+	c.XORKeyStream(dst, src)
+	c.Reset()
+
+	return nil
 }
 
 // XORKeyStream sets dst to the result of XORing src with the key stream.  Dst
-// and src may be the same slice but otherwise should not overlap.
+// and src may be the same slice but otherwise must not overlap.  If
+// len(dst) < len(src), or if dst and src partially overlap, XORKeyStream
+// will panic without consuming any key stream.
 func (c *Cipher) XORKeyStream(dst, src []byte) {
+	c.checkDestroyed()
 	if len(dst) < len(src) {
-		src = src[:len(dst)]
+		panic("chacha20: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("chacha20: invalid buffer overlap")
+	}
+
+	// Fast path: the input is entirely covered by the buffered keystream,
+	// which is common with many small calls.  Very short inputs are
+	// handled inline, as the call to xorBufBytes dominates.
+	if n := len(src); n <= api.BlockSize-c.off {
+		if n < 8 {
+			buf := c.buf[c.off : c.off+n]
+			dst = dst[:n]
+			for i, v := range src {
+				dst[i] = buf[i] ^ v
+			}
+			c.off += n
+		} else {
+			c.xorBufBytes(dst, src, n)
+		}
+		return
 	}
 
 	for remaining := len(src); remaining > 0; {
@@ -223,6 +565,89 @@ func (c *Cipher) XORKeyStream(dst, src []byte) {
 	}
 }
 
+// XORKeyStreamN sets dst to the result of XORing src with the key stream,
+// and returns the number of bytes processed.  Unlike XORKeyStream, if the
+// key stream per nonce limit would be exceeded, as much of src as possible
+// is processed and ErrKeyStreamExhausted is returned instead of panicking.
+func (c *Cipher) XORKeyStreamN(dst, src []byte) (int, error) {
+	if len(dst) < len(src) {
+		panic("chacha20: output smaller than input")
+	}
+
+	n, err := len(src), error(nil)
+	if c.mode == ModeIETF {
+		if remaining := c.ietfRemaining(); uint64(n) > remaining {
+			n, err = int(remaining), ErrKeyStreamExhausted
+		}
+	}
+	c.XORKeyStream(dst[:n], src[:n])
+
+	return n, err
+}
+
+// XORKeyStreamAt sets dst to the result of XORing src with the key stream
+// starting at byteOffset.  The cipher's key stream position is left
+// unchanged.  In IETF mode, ErrKeyStreamExhausted is returned if the
+// operation would exceed the key stream per nonce limit.
+func (c *Cipher) XORKeyStreamAt(dst, src []byte, byteOffset uint64) error {
+	if len(dst) < len(src) {
+		panic("chacha20: output smaller than input")
+	}
+
+	var saved cursor
+	c.saveCursor(&saved)
+	defer c.restoreCursor(&saved)
+
+	if err := c.seekBytes(byteOffset); err != nil {
+		return err
+	}
+	if c.mode == ModeIETF && uint64(len(src)) > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+	c.XORKeyStream(dst, src)
+
+	return nil
+}
+
+// KeyStreamAt sets dst to the raw keystream starting at byteOffset.  The
+// cipher's key stream position is left unchanged.  In IETF mode,
+// ErrKeyStreamExhausted is returned if the operation would exceed the key
+// stream per nonce limit.
+func (c *Cipher) KeyStreamAt(dst []byte, byteOffset uint64) error {
+	var saved cursor
+	c.saveCursor(&saved)
+	defer c.restoreCursor(&saved)
+
+	if err := c.seekBytes(byteOffset); err != nil {
+		return err
+	}
+	if c.mode == ModeIETF && uint64(len(dst)) > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+	c.KeyStream(dst)
+
+	return nil
+}
+
+// XORKeyStreamWithCounter sets the block counter to startCounter, and then
+// sets dst to the result of XORing src with the key stream, leaving the
+// block counter advanced.  The nonce is unaltered.  As with XORKeyStream,
+// it will panic if the key stream per nonce limit would be exceeded in IETF
+// mode.
+func (c *Cipher) XORKeyStreamWithCounter(dst, src []byte, startCounter uint32) {
+	if len(dst) < len(src) {
+		panic("chacha20: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("chacha20: invalid buffer overlap")
+	}
+
+	if err := c.Seek(uint64(startCounter)); err != nil {
+		panic("chacha20: will exceed key stream per nonce limit")
+	}
+	c.XORKeyStream(dst, src)
+}
+
 func (c *Cipher) xorBufBytes(dst, src []byte, n int) {
 	// Force bounds check elimination.
 	buf := c.buf[c.off:]
@@ -230,7 +655,14 @@ func (c *Cipher) xorBufBytes(dst, src []byte, n int) {
 	_ = dst[n-1]
 	_ = src[n-1]
 
-	for i := 0; i < n; i++ {
+	// Combine 8 bytes at a time, then handle the tail byte-wise.
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		k := binary.LittleEndian.Uint64(buf[i : i+8])
+		s := binary.LittleEndian.Uint64(src[i : i+8])
+		binary.LittleEndian.PutUint64(dst[i:i+8], k^s)
+	}
+	for ; i < n; i++ {
 		dst[i] = buf[i] ^ src[i]
 	}
 	c.off += n
@@ -238,6 +670,7 @@ func (c *Cipher) xorBufBytes(dst, src []byte, n int) {
 
 // KeyStream sets dst to the raw keystream.
 func (c *Cipher) KeyStream(dst []byte) {
+	c.checkDestroyed()
 	for remaining := len(dst); remaining > 0; {
 		// Process multiple blocks at once.
 		if c.off == api.BlockSize {
@@ -272,8 +705,178 @@ func (c *Cipher) KeyStream(dst []byte) {
 	}
 }
 
+// Discard advances the key stream by n bytes, as if XORKeyStream was called
+// with n bytes of input, without generating the skipped key stream.  In IETF
+// mode, ErrKeyStreamExhausted is returned without advancing the key stream
+// if the key stream per nonce limit would be exceeded.
+func (c *Cipher) Discard(n uint64) error {
+	c.checkDestroyed()
+	if c.mode == ModeIETF && n > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+
+	buffered := uint64(api.BlockSize - c.off)
+	if n <= buffered {
+		c.off += int(n)
+		return nil
+	}
+	n -= buffered
+
+	ctr := c.counter() + n/api.BlockSize
+	c.state[12] = uint32(ctr)
+	if c.mode != ModeIETF {
+		c.state[13] = uint32(ctr >> 32)
+	}
+	c.off = api.BlockSize
+	if off := int(n % api.BlockSize); off != 0 {
+		c.doBlocks(c.buf[:], nil, 1)
+		c.off = off
+	}
+
+	return nil
+}
+
+// KeyStreamErr sets dst to the raw keystream.  Unlike KeyStream, if the key
+// stream per nonce limit would be exceeded, as much of dst as possible is
+// filled and ErrKeyStreamExhausted is returned instead of panicking.
+func (c *Cipher) KeyStreamErr(dst []byte) error {
+	n, err := len(dst), error(nil)
+	if c.mode == ModeIETF {
+		if remaining := c.ietfRemaining(); uint64(n) > remaining {
+			n, err = int(remaining), ErrKeyStreamExhausted
+		}
+	}
+	c.KeyStream(dst[:n])
+
+	return err
+}
+
+// NextKeyStream returns the next n bytes of raw keystream, advancing the
+// cipher by n bytes (eg: for callers that combine the key stream with data
+// using their own logic).  The same limits as KeyStream apply.
+func (c *Cipher) NextKeyStream(n int) []byte {
+	if n < 0 {
+		panic("chacha20: negative length")
+	}
+	b := make([]byte, n)
+	c.KeyStream(b)
+
+	return b
+}
+
+// KeyStreamBlocks sets the first n * 64 bytes of dst to the raw keystream,
+// advancing the cipher by n blocks.  Unlike KeyStream, it returns
+// io.ErrShortBuffer if dst is too small, and ErrKeyStreamExhausted if the
+// key stream per nonce limit would be exceeded, without generating any
+// keystream.
+func (c *Cipher) KeyStreamBlocks(dst []byte, n int) error {
+	if n < 0 {
+		panic("chacha20: negative block count")
+	}
+	if len(dst)/api.BlockSize < n {
+		return io.ErrShortBuffer
+	}
+	sz := n * api.BlockSize
+	if c.mode == ModeIETF && uint64(sz) > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+
+	c.KeyStream(dst[:sz])
+
+	return nil
+}
+
+// KeyStreamWords sets dst to the raw keystream, as successive little endian
+// 32 bit words, advancing the cipher by len(dst) * 4 bytes.  In IETF mode,
+// ErrKeyStreamExhausted is returned without generating any keystream if the
+// key stream per nonce limit would be exceeded.
+func (c *Cipher) KeyStreamWords(dst []uint32) error {
+	c.checkDestroyed()
+	if c.mode == ModeIETF && uint64(len(dst))*4 > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+
+	var buf [8 * api.BlockSize]byte
+	for len(dst) > 0 {
+		n := len(dst)
+		if n > len(buf)/4 {
+			n = len(buf) / 4
+		}
+		b := buf[:n*4]
+		c.KeyStream(b)
+		for i := range dst[:n] {
+			dst[i] = binary.LittleEndian.Uint32(b[i*4:])
+		}
+		dst = dst[n:]
+	}
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	return nil
+}
+
+// FirstBlock returns the key stream block with a block counter of 0 (eg: for
+// one-time key derivation), without altering the key stream position.
+func (c *Cipher) FirstBlock() [api.BlockSize]byte {
+	c.checkDestroyed()
+	var block [api.BlockSize]byte
+
+	ctr0, ctr1 := c.state[12], c.state[13]
+	c.state[12] = 0
+	if c.mode != ModeIETF {
+		c.state[13] = 0
+	}
+	activeImpl.Blocks(&c.state, block[:], nil, 1)
+	c.state[12], c.state[13] = ctr0, ctr1
+
+	return block
+}
+
+// Remaining returns the number of key stream bytes that can be generated
+// before the block counter would wrap.  As the 64 bit block counter used by
+// ChaCha20 and XChaCha20 allows for more than 2^64 bytes, the return value
+// saturates at math.MaxUint64.
+func (c *Cipher) Remaining() uint64 {
+	if c.mode == ModeIETF {
+		return c.ietfRemaining()
+	}
+
+	ctr := c.counter()
+	buffered := uint64(api.BlockSize - c.off)
+	if ctr == 0 {
+		// With buffered key stream, the final block was just generated and
+		// the counter has wrapped.
+		if buffered != 0 {
+			return buffered
+		}
+		return math.MaxUint64
+	}
+	nrBlocks := -ctr // 2^64 - ctr
+	if nrBlocks > (math.MaxUint64-buffered)/api.BlockSize {
+		return math.MaxUint64
+	}
+	return nrBlocks*api.BlockSize + buffered
+}
+
+// StateMatrix returns the 16 word ChaCha20 state (constants, key, block
+// counter, and nonce) that will be used for the next block of key stream,
+// for debugging.  For XChaCha20, the key is the HChaCha20 derived subkey.
+// The return value contains key material, and should be handled
+// accordingly.
+func (c *Cipher) StateMatrix() [api.StateSize]uint32 {
+	return c.state
+}
+
+// ietfRemaining returns the number of key stream bytes that can be generated
+// before the IETF 32 bit block counter is exhausted.
+func (c *Cipher) ietfRemaining() uint64 {
+	nrBlocks := uint64(math.MaxUint32 - c.state[12])
+	return nrBlocks*api.BlockSize + uint64(api.BlockSize-c.off)
+}
+
 func (c *Cipher) doBlocks(dst, src []byte, nrBlocks int) {
-	if c.ietf {
+	if c.mode == ModeIETF {
 		ctr := uint64(c.state[12])
 		if ctr+uint64(nrBlocks) > math.MaxUint32 {
 			panic("chacha20: will exceed key stream per nonce limit")
@@ -283,6 +886,18 @@ func (c *Cipher) doBlocks(dst, src []byte, nrBlocks int) {
 	activeImpl.Blocks(&c.state, dst, src, nrBlocks)
 }
 
+// ActiveImplementation returns the name of the backend in use (eg: for
+// diagnostics).
+func ActiveImplementation() string {
+	return activeImpl.Name()
+}
+
+// IsReferenceImpl returns true iff the backend in use is the portable pure
+// Go reference implementation.
+func IsReferenceImpl() bool {
+	return activeImpl == ref.Impl
+}
+
 func init() {
 	supportedImpls = hardware.Register(supportedImpls)
 	supportedImpls = ref.Register(supportedImpls)
@@ -0,0 +1,138 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package chacha20
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/fengxuway/chacha20/internal/api"
+	"github.com/fengxuway/chacha20/internal/ref"
+)
+
+// fuzzMaxLength is the maximum number of bytes processed per fuzz input.
+const fuzzMaxLength = 1 << 16
+
+func FuzzImplConsistency(f *testing.F) {
+	var (
+		key    [KeySize]byte
+		nonce  [XNonceSize]byte
+		allOps = []byte{0x00, 0x01, 0x7e, 0x7f, 0xff, 0xfe, 0x80, 0x81}
+	)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(i + KeySize)
+	}
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		f.Add(key[:], nonce[:nonceSize], uint64(0), uint16(1024), allOps)
+		f.Add(key[:], nonce[:nonceSize], uint64(math.MaxUint32-2), uint16(4096), allOps)
+		f.Add(key[:], nonce[:nonceSize], uint64(math.MaxUint64), uint16(8192), []byte{0xff})
+	}
+
+	f.Fuzz(func(t *testing.T, key, nonce []byte, seekOffset uint64, length uint16, ops []byte) {
+		if len(key) != KeySize {
+			t.Skip()
+		}
+		switch len(nonce) {
+		case NonceSize, XNonceSize:
+		case INonceSize:
+			// Leave enough room to avoid exhausting the key stream.
+			seekOffset %= math.MaxUint32 - fuzzMaxLength/api.BlockSize - 1
+		default:
+			t.Skip()
+		}
+
+		// The fuzzer may hand out key and nonce slices that share memory,
+		// which New rejects, so use private copies.
+		key = append([]byte{}, key...)
+		nonce = append([]byte{}, nonce...)
+
+		expected := fuzzImplOutput(t, ref.Impl, key, nonce, seekOffset, int(length), ops)
+		for _, impl := range supportedImpls {
+			out := fuzzImplOutput(t, impl, key, nonce, seekOffset, int(length), ops)
+			if !bytes.Equal(expected, out) {
+				t.Fatalf("%s: output mismatch vs %s", impl.Name(), ref.Impl.Name())
+			}
+		}
+	})
+}
+
+func fuzzImplOutput(t *testing.T, impl api.Implementation, key, nonce []byte, seekOffset uint64, length int, ops []byte) []byte {
+	oldImpl := activeImpl
+	defer func() {
+		activeImpl = oldImpl
+	}()
+	activeImpl = impl
+
+	c, err := New(key, nonce)
+	if err != nil {
+		t.Fatalf("%s: New: %v", impl.Name(), err)
+	}
+	if err = c.Seek(seekOffset); err != nil {
+		t.Fatalf("%s: Seek(%d): %v", impl.Name(), seekOffset, err)
+	}
+
+	// Each op byte selects the size of the next call (in the low 7 bits,
+	// scaled so that multi-block calls happen), and if the call should be
+	// KeyStream or XORKeyStream (the high bit).  When the ops are exhausted,
+	// the remainder is processed with a single XORKeyStream call.
+	//
+	// The output is followed by a canary, to catch backends that write past
+	// the end of dst.
+	const canarySize = 2 * api.BlockSize
+	backing := make([]byte, length+canarySize)
+	for i := range backing {
+		backing[i] = byte(i)
+	}
+	out := backing[:length]
+	for off := 0; off < length; {
+		sz, keyStream := length-off, false
+		if len(ops) > 0 {
+			sz = int(ops[0]&0x7f) * 13
+			keyStream = ops[0]&0x80 != 0
+			ops = ops[1:]
+			if sz > length-off {
+				sz = length - off
+			}
+		}
+
+		// Zero length calls must not alter the key stream position.
+		b := out[off : off+sz]
+		if sz == 0 {
+			b = nil
+		}
+		if keyStream {
+			c.KeyStream(b)
+		} else {
+			c.XORKeyStream(b, b)
+		}
+		off += sz
+	}
+	for i, b := range backing[length:] {
+		if b != byte(length+i) {
+			t.Fatalf("%s: wrote past the end of dst at %d", impl.Name(), length+i)
+		}
+	}
+
+	return out
+}
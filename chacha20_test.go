@@ -16,8 +16,11 @@
 package chacha20
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha512"
+	"encoding/binary"
+	"io"
 	"math"
 	"strconv"
 	"testing"
@@ -25,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/fengxuway/chacha20/internal/api"
+	"github.com/fengxuway/chacha20/internal/ref"
 )
 
 // Test vectors taken from:
@@ -351,6 +355,24 @@ func doTestBasic(t *testing.T) {
 	t.Run("RoundTrip", doTestBasicRoundTrip)
 	t.Run("Counter", doTestBasicCounter)
 	t.Run("IETFCounter", doTestBasicIETFCounter)
+	t.Run("SeekLimits", doTestBasicSeekLimits)
+	t.Run("DJBLargeSeek", doTestBasicDJBLargeSeek)
+	t.Run("ShortDst", doTestBasicShortDst)
+	t.Run("Overlap", doTestBasicOverlap)
+	t.Run("XORKeyStreamN", doTestBasicXORKeyStreamN)
+	t.Run("KeyStreamErr", doTestBasicKeyStreamErr)
+	t.Run("NextKeyStream", doTestBasicNextKeyStream)
+	t.Run("Discard", doTestBasicDiscard)
+	t.Run("KeyStreamWords", doTestBasicKeyStreamWords)
+	t.Run("Remaining", doTestBasicRemaining)
+	t.Run("KeyStreamBlocks", doTestBasicKeyStreamBlocks)
+	t.Run("Allocs", doTestBasicAllocs)
+	t.Run("XORKeyStreamAt", doTestBasicXORKeyStreamAt)
+	t.Run("KeyStreamAt", doTestBasicKeyStreamAt)
+	t.Run("XORKeyStreamWithCounter", doTestBasicXORKeyStreamWithCounter)
+	t.Run("FirstBlock", doTestBasicFirstBlock)
+	t.Run("ZeroLength", doTestBasicZeroLength)
+	t.Run("Bounds", doTestBasicBounds)
 	t.Run("Incremental", doTestBasicIncremental)
 }
 
@@ -422,63 +444,1412 @@ func doTestBasicIETFCounter(t *testing.T) {
 	}, "KeyStream - counter would wrap")
 }
 
+func doTestBasicDJBLargeSeek(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		expected, out [4 * api.BlockSize]byte
+	)
+
+	c, err := NewDJB(key[:], nonce[:])
+	require.NoError(err, "NewDJB")
+
+	// Generate each block individually, from an explicit counter.
+	ctrs := []uint64{math.MaxUint64 - 2, math.MaxUint64 - 1, math.MaxUint64, 0}
+	for i, ctr := range ctrs {
+		err = c.Seek(ctr)
+		require.NoErrorf(err, "Seek(%d)", ctr)
+		require.Equal(uint32(ctr), c.state[12], "Seek - state[12]")
+		require.Equal(uint32(ctr>>32), c.state[13], "Seek - state[13]")
+		c.KeyStream(expected[i*api.BlockSize : (i+1)*api.BlockSize])
+	}
+
+	// The key stream must be continuous across the 64 bit counter wrap.
+	err = c.Seek(ctrs[0])
+	require.NoError(err, "Seek")
+	c.KeyStream(out[:])
+	require.Equal(expected, out, "KeyStream - multi-block across wrap")
+	require.Equal([2]uint32{1, 0}, [2]uint32{c.state[12], c.state[13]}, "KeyStream - counter after wrap")
+
+	// Byte offsets (as used by XORKeyStreamAt) are split into a block
+	// counter and an intra-block offset, without overflowing.
+	const lastBlock = math.MaxUint64 / api.BlockSize
+	err = c.Seek(lastBlock)
+	require.NoError(err, "Seek")
+	c.KeyStream(expected[:api.BlockSize])
+	for i := range out {
+		out[i] = 0
+	}
+	err = c.XORKeyStreamAt(out[:8], out[:8], math.MaxUint64-7)
+	require.NoError(err, "XORKeyStreamAt")
+	require.Equal(expected[api.BlockSize-8:api.BlockSize], out[:8], "XORKeyStreamAt - top of range")
+
+	// The last block boundary addressable by a byte offset, against a
+	// reference computation from an explicitly constructed state.
+	const topOffset = 0xFFFFFFFFFFFFFFC0
+	var x [api.StateSize]uint32
+	copy(x[:], c.state[:])
+	x[12], x[13] = 0xFFFFFFFF, 0x03FFFFFF
+	ref.Impl.Blocks(&x, expected[api.BlockSize:2*api.BlockSize], nil, 1)
+	x[12], x[13] = 0xFFFFFFFE, 0x03FFFFFF
+	ref.Impl.Blocks(&x, expected[:api.BlockSize], nil, 1)
+
+	err = c.seekBytes(topOffset)
+	require.NoError(err, "seekBytes")
+	require.Equal([2]uint32{0xFFFFFFFF, 0x03FFFFFF}, [2]uint32{c.state[12], c.state[13]}, "seekBytes - state words")
+
+	err = c.KeyStreamAt(out[:2*api.BlockSize], topOffset-api.BlockSize)
+	require.NoError(err, "KeyStreamAt")
+	require.Equal(expected[:2*api.BlockSize], out[:2*api.BlockSize], "KeyStreamAt - last blocks before overflow")
+}
+
+func doTestBasicSeekLimits(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key    [KeySize]byte
+		nonce  [NonceSize]byte
+		iNonce [INonceSize]byte
+
+		block0 [api.BlockSize]byte
+		head   [8 * api.BlockSize]byte
+		blocks [16 * api.BlockSize]byte
+	)
+
+	// IETF: The last usable block is math.MaxUint32 - 1.
+	c, err := New(key[:], iNonce[:])
+	require.NoError(err, "New - IETF")
+
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek(math.MaxUint32 - 1)")
+	err = c.Seek(math.MaxUint32)
+	require.Equal(ErrInvalidCounter, err, "Seek(math.MaxUint32)")
+	err = c.Seek(math.MaxUint32 + 1)
+	require.Equal(ErrInvalidCounter, err, "Seek(math.MaxUint32 + 1)")
+
+	// A failed Seek must leave the counter untouched.
+	require.NotPanics(func() {
+		c.KeyStream(block0[:])
+	}, "KeyStream - after failed Seek")
+
+	// DJB: The 64 bit counter is allowed to wrap.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.KeyStream(head[:])
+
+	err = c.Seek(math.MaxUint64 - 7)
+	require.NoError(err, "Seek(math.MaxUint64 - 7)")
+	c.KeyStream(blocks[:])
+	require.Equal(head[:], blocks[8*api.BlockSize:], "KeyStream - 64 bit counter wraps")
+}
+
+func doTestBasicShortDst(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		expected, out [3 * api.BlockSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.KeyStream(expected[:])
+
+	// Start mid-block, so that there is buffered key stream.
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+	c.KeyStream(out[:7])
+
+	require.Panics(func() {
+		c.XORKeyStream(out[7:8], out[7:])
+	}, "XORKeyStream - short dst")
+	c.KeyStream(out[7:])
+	require.Equal(expected, out, "KeyStream - after short dst")
+}
+
+func doTestBasicOverlap(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		expected, buf [4 * api.BlockSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.KeyStream(expected[:api.BlockSize])
+
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+	for _, off := range []int{1, 3, 63, 64, 65, 2*api.BlockSize - 1} {
+		require.Panicsf(func() {
+			c.XORKeyStream(buf[off:], buf[:len(buf)-off])
+		}, "XORKeyStream - dst after src: %d", off)
+		require.Panicsf(func() {
+			c.XORKeyStream(buf[:len(buf)-off], buf[off:])
+		}, "XORKeyStream - dst before src: %d", off)
+	}
+
+	// Adjacent and exactly overlapping buffers are fine.
+	half := len(buf) / 2
+	require.NotPanics(func() {
+		c.XORKeyStream(buf[:half], buf[half:])
+	}, "XORKeyStream - adjacent")
+	require.Equal(expected[:api.BlockSize], buf[:api.BlockSize], "XORKeyStream - after failed calls")
+	require.NotPanics(func() {
+		c.XORKeyStream(buf[:], buf[:])
+	}, "XORKeyStream - in-place")
+}
+
+func doTestBasicXORKeyStreamN(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+
+		expected [api.BlockSize]byte
+		buf      [3 * api.BlockSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	c.KeyStream(expected[:])
+
+	// Only the remainder of the last block should get processed.
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek - resetting")
+	c.KeyStream(buf[:10])
+	n, err := c.XORKeyStreamN(buf[10:], buf[10:])
+	require.Equal(ErrKeyStreamExhausted, err, "XORKeyStreamN - exhausted")
+	require.Equal(api.BlockSize-10, n, "XORKeyStreamN - partial")
+	require.Equal(expected[:], buf[:api.BlockSize], "XORKeyStreamN - output")
+
+	n, err = c.XORKeyStreamN(buf[:], buf[:])
+	require.Equal(ErrKeyStreamExhausted, err, "XORKeyStreamN - exhausted, again")
+	require.Zero(n, "XORKeyStreamN - nothing processed")
+
+	// The 64 bit counter is never exhausted.
+	c, err = New(key[:], nonce[:NonceSize])
+	require.NoError(err, "New - 64 bit nonce")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	n, err = c.XORKeyStreamN(buf[:], buf[:])
+	require.NoError(err, "XORKeyStreamN - 64 bit counter")
+	require.Equal(len(buf), n, "XORKeyStreamN - 64 bit counter")
+}
+
+func doTestBasicKeyStreamErr(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+
+		expected [api.BlockSize]byte
+		buf      [3 * api.BlockSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	c.KeyStream(expected[:])
+
+	// Only the remainder of the last block should get generated.
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek - resetting")
+	err = c.KeyStreamErr(buf[:10])
+	require.NoError(err, "KeyStreamErr")
+	err = c.KeyStreamErr(buf[10:])
+	require.Equal(ErrKeyStreamExhausted, err, "KeyStreamErr - exhausted")
+	require.Equal(expected[:], buf[:api.BlockSize], "KeyStreamErr - output")
+	require.Equal(make([]byte, 2*api.BlockSize), buf[api.BlockSize:], "KeyStreamErr - past the limit")
+
+	err = c.KeyStreamErr(buf[:1])
+	require.Equal(ErrKeyStreamExhausted, err, "KeyStreamErr - exhausted, again")
+
+	// The 64 bit counter is never exhausted.
+	c, err = New(key[:], nonce[:NonceSize])
+	require.NoError(err, "New - 64 bit nonce")
+	err = c.Seek(math.MaxUint64)
+	require.NoError(err, "Seek")
+	err = c.KeyStreamErr(buf[:])
+	require.NoError(err, "KeyStreamErr - 64 bit counter")
+}
+
+func doTestBasicNextKeyStream(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		src, expected [3*api.BlockSize + 17]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(src[:])
+	require.NoError(err, "rand.Read(src)")
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.XORKeyStream(expected[:], src[:])
+
+	// Split the calls so that buffered key stream is exercised.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	dst := make([]byte, 0, len(src))
+	for _, n := range []int{0, 5, api.BlockSize, 2*api.BlockSize - 5, 17} {
+		ks := c.NextKeyStream(n)
+		require.Len(ks, n, "NextKeyStream - length")
+		for _, b := range ks {
+			dst = append(dst, src[len(dst)]^b)
+		}
+	}
+	require.Equal(expected[:], dst, "NextKeyStream - XORed output")
+
+	require.Panics(func() {
+		c.NextKeyStream(-1)
+	}, "NextKeyStream - negative length")
+
+	c, err = New(key[:], nonce[:INonceSize])
+	require.NoError(err, "New - IETF")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	require.Panics(func() {
+		c.NextKeyStream(api.BlockSize + 1)
+	}, "NextKeyStream - exhausted")
+}
+
+func doTestBasicDiscard(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected [6 * api.BlockSize]byte
+		out      [api.BlockSize + 7]byte
+	)
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(expected[:])
+
+		for _, pre := range []int{0, 10, api.BlockSize} {
+			for _, n := range []int{0, 1, 53, 54, 64, 200} {
+				c, err = New(key[:], nonce[:nonceSize])
+				require.NoError(err, "New")
+				c.KeyStream(out[:pre])
+
+				err = c.Discard(uint64(n))
+				require.NoErrorf(err, "Discard: %d, %d", pre, n)
+				c.KeyStream(out[:])
+				off := pre + n
+				require.Equalf(expected[off:off+len(out)], out[:], "KeyStream - after Discard: %d, %d, %d", nonceSize, pre, n)
+			}
+		}
+	}
+
+	// IETF mode does not advance past the key stream limit.
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	c.KeyStream(expected[:api.BlockSize])
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek - resetting")
+	c.KeyStream(out[:1])
+
+	err = c.Discard(api.BlockSize)
+	require.Equal(ErrKeyStreamExhausted, err, "Discard - exhausted")
+	err = c.Discard(api.BlockSize - 2)
+	require.NoError(err, "Discard - up to the limit")
+	c.KeyStream(out[:1])
+	require.Equal(expected[api.BlockSize-1], out[0], "KeyStream - last byte")
+
+	// The 64 bit counter wraps, as with XORKeyStream.
+	c, err = NewDJB(key[:], nonce[:NonceSize])
+	require.NoError(err, "NewDJB")
+	c.KeyStream(expected[:api.BlockSize])
+	err = c.Seek(math.MaxUint64)
+	require.NoError(err, "Seek")
+	err = c.Discard(api.BlockSize + 3)
+	require.NoError(err, "Discard - wrap")
+	c.KeyStream(out[:api.BlockSize-3])
+	require.Equal(expected[3:api.BlockSize], out[:api.BlockSize-3], "KeyStream - after wrap")
+}
+
+func doTestBasicKeyStreamWords(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		expected [20 * api.BlockSize]byte
+		words    [(len(expected) - 2) / 4]uint32
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.KeyStream(expected[:])
+
+	// Start unaligned, and span more than the internal buffer.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	var b [2]byte
+	c.KeyStream(b[:])
+	err = c.KeyStreamWords(words[:3])
+	require.NoError(err, "KeyStreamWords")
+	err = c.KeyStreamWords(words[3:])
+	require.NoError(err, "KeyStreamWords - remainder")
+	for i, w := range words {
+		require.Equalf(binary.LittleEndian.Uint32(expected[2+i*4:]), w, "KeyStreamWords - word %d", i)
+	}
+
+	// IETF mode does not generate past the key stream limit.
+	c, err = New(key[:], make([]byte, INonceSize))
+	require.NoError(err, "New - IETF")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	err = c.KeyStreamWords(words[:api.BlockSize/4+1])
+	require.Equal(ErrKeyStreamExhausted, err, "KeyStreamWords - exhausted")
+	err = c.KeyStreamWords(words[:api.BlockSize/4])
+	require.NoError(err, "KeyStreamWords - up to the limit")
+}
+
+func doTestBasicRemaining(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		buf [api.BlockSize]byte
+	)
+
+	// IETF mode is limited to 2^32 - 1 blocks.
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	require.EqualValues(uint64(math.MaxUint32)*api.BlockSize, c.Remaining(), "Remaining - IETF start")
+	c.KeyStream(buf[:10])
+	require.EqualValues(uint64(math.MaxUint32)*api.BlockSize-10, c.Remaining(), "Remaining - IETF partial")
+
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	require.EqualValues(api.BlockSize, c.Remaining(), "Remaining - IETF near limit")
+	c.KeyStream(buf[:3])
+	require.EqualValues(api.BlockSize-3, c.Remaining(), "Remaining - IETF near limit, partial")
+	c.KeyStream(buf[3:])
+	require.Zero(c.Remaining(), "Remaining - IETF exhausted")
+
+	// The 64 bit block counter saturates.
+	for _, nonceSize := range []int{NonceSize, XNonceSize} {
+		c, err = New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		require.EqualValues(uint64(math.MaxUint64), c.Remaining(), "Remaining - start")
+		c.KeyStream(buf[:10])
+		require.EqualValues(uint64(math.MaxUint64), c.Remaining(), "Remaining - partial")
+
+		err = c.Seek(math.MaxUint64 - 1)
+		require.NoError(err, "Seek")
+		require.EqualValues(2*api.BlockSize, c.Remaining(), "Remaining - near wrap")
+		c.KeyStream(buf[:7])
+		require.EqualValues(2*api.BlockSize-7, c.Remaining(), "Remaining - near wrap, partial")
+	}
+}
+
+func doTestBasicKeyStreamBlocks(t *testing.T) {
+	require := require.New(t)
+
+	const nrBlocks = 11
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+
+		expected, out [nrBlocks * api.BlockSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	for i := 0; i < nrBlocks; i++ {
+		c.KeyStream(expected[i*api.BlockSize : (i+1)*api.BlockSize])
+	}
+
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+	err = c.KeyStreamBlocks(out[:], nrBlocks)
+	require.NoError(err, "KeyStreamBlocks")
+	require.Equal(expected, out, "KeyStreamBlocks - output")
+
+	err = c.KeyStreamBlocks(out[:api.BlockSize-1], 1)
+	require.Equal(io.ErrShortBuffer, err, "KeyStreamBlocks - short dst")
+
+	err = c.Seek(math.MaxUint32 - nrBlocks + 1)
+	require.NoError(err, "Seek - near limit")
+	err = c.KeyStreamBlocks(out[:], nrBlocks)
+	require.Equal(ErrKeyStreamExhausted, err, "KeyStreamBlocks - exhausted")
+	err = c.KeyStreamBlocks(out[:], nrBlocks-1)
+	require.NoError(err, "KeyStreamBlocks - up to limit")
+}
+
+func doTestBasicAllocs(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		buf [4096]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.XORKeyStream(buf[:], buf[:])
+	})
+	require.Zero(allocs, "XORKeyStream - aligned")
+
+	allocs = testing.AllocsPerRun(100, func() {
+		c.XORKeyStream(buf[:len(buf)-3], buf[:len(buf)-3])
+		c.KeyStream(buf[:5])
+	})
+	require.Zero(allocs, "XORKeyStream/KeyStream - unaligned")
+}
+
+func doTestBasicXORKeyStreamAt(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+
+		stream [10 * api.BlockSize]byte
+		buf    [3*api.BlockSize + 5]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.KeyStream(stream[:])
+
+	// Leave the cipher mid-block, with buffered key stream.
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+	c.KeyStream(buf[:17])
+
+	for _, off := range []int{0, 1, 17, 63, 64, 65, 127, 200, 320} {
+		for _, n := range []int{0, 1, 47, 64, len(buf)} {
+			for i := range buf[:n] {
+				buf[i] = byte(i)
+			}
+			err = c.XORKeyStreamAt(buf[:n], buf[:n], uint64(off))
+			require.NoErrorf(err, "XORKeyStreamAt(%d, %d)", off, n)
+			for i := range buf[:n] {
+				buf[i] ^= byte(i)
+			}
+			require.Equalf(stream[off:off+n], buf[:n], "XORKeyStreamAt(%d, %d)", off, n)
+		}
+	}
+
+	// The key stream position must be unchanged.
+	c.KeyStream(buf[:])
+	require.Equal(stream[17:17+len(buf)], buf[:], "KeyStream - after XORKeyStreamAt")
+
+	lastBlock := uint64(math.MaxUint32-1) * api.BlockSize
+	err = c.XORKeyStreamAt(buf[:api.BlockSize-3], buf[:api.BlockSize-3], lastBlock+3)
+	require.NoError(err, "XORKeyStreamAt - last block")
+	err = c.XORKeyStreamAt(buf[:api.BlockSize-2], buf[:api.BlockSize-2], lastBlock+3)
+	require.Equal(ErrKeyStreamExhausted, err, "XORKeyStreamAt - exhausted")
+	err = c.XORKeyStreamAt(buf[:1], buf[:1], lastBlock+api.BlockSize)
+	require.Equal(ErrInvalidCounter, err, "XORKeyStreamAt - out of range")
+
+	c.KeyStream(buf[:])
+	require.Equal(stream[17+len(buf):17+2*len(buf)], buf[:], "KeyStream - after failed XORKeyStreamAt")
+}
+
+func doTestBasicKeyStreamAt(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		stream [10 * api.BlockSize]byte
+		buf    [3*api.BlockSize + 5]byte
+	)
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(stream[:])
+
+		// Leave the cipher mid-block, with buffered key stream.
+		err = c.Seek(0)
+		require.NoError(err, "Seek")
+		c.KeyStream(buf[:17])
+
+		for _, off := range []int{0, 1, 17, 63, 64, 65, 127, 200, 320} {
+			for _, n := range []int{0, 1, 47, 64, len(buf)} {
+				err = c.KeyStreamAt(buf[:n], uint64(off))
+				require.NoErrorf(err, "KeyStreamAt(%d, %d)", off, n)
+				require.Equalf(stream[off:off+n], buf[:n], "KeyStreamAt(%d, %d)", off, n)
+			}
+		}
+
+		// The key stream position must be unchanged.
+		c.KeyStream(buf[:])
+		require.Equal(stream[17:17+len(buf)], buf[:], "KeyStream - after KeyStreamAt")
+	}
+
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	c.KeyStream(stream[:])
+	err = c.Seek(0)
+	require.NoError(err, "Seek")
+
+	lastBlock := uint64(math.MaxUint32-1) * api.BlockSize
+	err = c.KeyStreamAt(buf[:api.BlockSize-3], lastBlock+3)
+	require.NoError(err, "KeyStreamAt - last block")
+	err = c.KeyStreamAt(buf[:api.BlockSize-2], lastBlock+3)
+	require.Equal(ErrKeyStreamExhausted, err, "KeyStreamAt - exhausted")
+	err = c.KeyStreamAt(buf[:1], lastBlock+api.BlockSize)
+	require.Equal(ErrInvalidCounter, err, "KeyStreamAt - out of range")
+
+	c.KeyStream(buf[:])
+	require.Equal(stream[:len(buf)], buf[:], "KeyStream - after failed KeyStreamAt")
+}
+
+func doTestBasicXORKeyStreamWithCounter(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected, out [3*api.BlockSize + 9]byte
+	)
+	_, err := rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		for _, ctr := range []uint32{0, 1, 12345, math.MaxUint32 - 4} {
+			c, err := New(key[:], nonce[:nonceSize])
+			require.NoError(err, "New")
+			err = c.XORKeyStreamAt(expected[:], expected[:], uint64(ctr)*api.BlockSize)
+			require.NoError(err, "XORKeyStreamAt")
+
+			// Start from an unaligned position, which must be discarded.
+			c.KeyStream(out[:7])
+			for i := range out {
+				out[i] = 0
+			}
+			c.XORKeyStreamWithCounter(out[:api.BlockSize+5], out[:api.BlockSize+5], ctr)
+			c.XORKeyStream(out[api.BlockSize+5:], out[api.BlockSize+5:])
+			require.Equalf(expected, out, "XORKeyStreamWithCounter: %d, %d", nonceSize, ctr)
+			require.Equal(nonce[:nonceSize], c.Nonce(), "Nonce")
+
+			for i := range expected {
+				expected[i] = 0
+			}
+		}
+	}
+
+	// IETF mode still enforces the key stream limit.
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	require.Panics(func() {
+		c.XORKeyStreamWithCounter(out[:1], out[:1], math.MaxUint32)
+	}, "XORKeyStreamWithCounter - counter out of range")
+	require.Panics(func() {
+		c.XORKeyStreamWithCounter(out[:], out[:], math.MaxUint32-1)
+	}, "XORKeyStreamWithCounter - counter would wrap")
+}
+
+func doTestBasicFirstBlock(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		stream [3 * api.BlockSize]byte
+		buf    [api.BlockSize]byte
+	)
+	for i := range nonce {
+		nonce[i] = byte(i + 1)
+	}
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(stream[:])
+
+		err = c.Seek(1)
+		require.NoError(err, "Seek")
+		c.KeyStream(buf[:3])
+
+		block := c.FirstBlock()
+		require.Equal(stream[:api.BlockSize], block[:], "FirstBlock")
+
+		// The key stream position must be unchanged.
+		c.KeyStream(buf[:])
+		require.Equal(stream[api.BlockSize+3:2*api.BlockSize+3], buf[:], "KeyStream - after FirstBlock")
+	}
+}
+
+func doTestBasicZeroLength(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected, out [2 * api.BlockSize]byte
+	)
+
+	zeroLengthOps := func(c *Cipher) {
+		c.XORKeyStream(nil, nil)
+		c.XORKeyStream(out[:0], out[:0])
+		c.KeyStream(nil)
+		c.KeyStream(out[:0])
+
+		n, err := c.XORKeyStreamN(nil, nil)
+		require.NoError(err, "XORKeyStreamN")
+		require.Equal(0, n, "XORKeyStreamN - n")
+		err = c.KeyStreamBlocks(nil, 0)
+		require.NoError(err, "KeyStreamBlocks")
+	}
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		for _, pos := range []int{0, 7, api.BlockSize} {
+			c, err := New(key[:], nonce[:nonceSize])
+			require.NoError(err, "New")
+			c.KeyStream(expected[:])
+
+			c, err = New(key[:], nonce[:nonceSize])
+			require.NoError(err, "New")
+			c.KeyStream(out[:pos])
+			zeroLengthOps(c)
+			c.KeyStream(out[pos:])
+			require.Equalf(expected, out, "KeyStream - after empty calls: %d, %d", nonceSize, pos)
+		}
+	}
+
+	// Zero length calls with the IETF key stream exhausted must not panic.
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	c.KeyStream(out[:api.BlockSize])
+	require.NotPanics(func() { zeroLengthOps(c) }, "zero length - exhausted")
+}
+
+func doTestBasicBounds(t *testing.T) {
+	require := require.New(t)
+
+	const canary = 0xa5
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+		big   [16 * api.BlockSize]byte
+		tmp   [api.BlockSize]byte
+	)
+
+	// Backends must not write past len(dst), even when the underlying
+	// array has room for the rest of the block.
+	for _, skip := range []int{0, 1, 63} {
+		for _, n := range []int{1, 63, 64, 65, 127, 255, 257, 511, 513, 1023} {
+			for _, op := range []string{"KeyStream", "XORKeyStream"} {
+				for i := range big {
+					big[i] = canary
+				}
+
+				c, err := New(key[:], nonce[:])
+				require.NoError(err, "New")
+				c.KeyStream(tmp[:skip])
+
+				dst := big[:n]
+				switch op {
+				case "KeyStream":
+					c.KeyStream(dst)
+				case "XORKeyStream":
+					c.XORKeyStream(dst, dst)
+				}
+				require.Equalf(bytes.Repeat([]byte{canary}, len(big)-n), big[n:], "%s(%d), skip %d: wrote past dst", op, n, skip)
+			}
+		}
+	}
+}
+
 func doTestBasicIncremental(t *testing.T) {
 	require := require.New(t)
 
 	var (
 		key   [KeySize]byte
-		nonce [NonceSize]byte
+		nonce [NonceSize]byte
+
+		buf [2048]byte
+
+		expectedDigest = []byte{
+			0xcf, 0xd6, 0xe9, 0x49, 0x22, 0x5b, 0x85, 0x4f,
+			0xe0, 0x49, 0x46, 0x49, 0x1e, 0x69, 0x35, 0xff,
+			0x05, 0xff, 0x98, 0x3d, 0x15, 0x54, 0xbc, 0x88,
+			0x5b, 0xca, 0x0e, 0xc8, 0x08, 0x2d, 0xd5, 0xb8,
+		}
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+
+	h := sha512.New512_256()
+	for i := 1; i <= 2048; i++ {
+		c.KeyStream(buf[:i])
+		_, _ = h.Write(buf[:i])
+	}
+
+	digest := h.Sum(nil)
+
+	require.Equal(expectedDigest, digest, "KeyStream digest matches")
+}
+
+func doTestVectors(t *testing.T) {
+	for _, v := range draftTestVectors {
+		t.Run(v.name, func(t *testing.T) {
+			require := require.New(t)
+			c, err := New(v.key, v.iv)
+			require.NoError(err, "New")
+
+			if v.seekOffset != 0 {
+				err = c.Seek(v.seekOffset)
+				require.NoErrorf(err, "Seek(%d)", v.seekOffset)
+			}
+
+			out := make([]byte, len(v.stream))
+			c.XORKeyStream(out, out)
+			require.EqualValues(v.stream, out, "XORKeyStream")
+
+			// Ensure that KeyStream tramples over dst.
+			_, err = rand.Read(out)
+			require.NoError(err, "rand.Read")
+
+			err = c.Seek(v.seekOffset)
+			require.NoErrorf(err, "Seek(%d) - resetting", v.seekOffset)
+			c.KeyStream(out)
+			require.EqualValues(v.stream, out, "KeyStream")
+		})
+	}
+}
+
+func TestNewVariants(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key    [KeySize]byte
+		nonce  [NonceSize]byte
+		iNonce [INonceSize]byte
+
+		expected, out [api.BlockSize]byte
+	)
+
+	c, err := NewDJB(key[:], nonce[:])
+	require.NoError(err, "NewDJB")
+	require.Equal(ModeDJB, c.Mode(), "NewDJB: Mode")
+	c.KeyStream(out[:])
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New - 64 bit nonce")
+	c.KeyStream(expected[:])
+	require.Equal(expected, out, "NewDJB: KeyStream")
+
+	c, err = NewIETF(key[:], iNonce[:])
+	require.NoError(err, "NewIETF")
+	require.Equal(ModeIETF, c.Mode(), "NewIETF: Mode")
+	c.KeyStream(out[:])
+	c, err = New(key[:], iNonce[:])
+	require.NoError(err, "New - 96 bit nonce")
+	c.KeyStream(expected[:])
+	require.Equal(expected, out, "NewIETF: KeyStream")
+
+	_, err = NewDJB(key[:], iNonce[:])
+	require.Equal(ErrInvalidNonce, err, "NewDJB - 96 bit nonce")
+	_, err = NewIETF(key[:], nonce[:])
+	require.Equal(ErrInvalidNonce, err, "NewIETF - 64 bit nonce")
+	_, err = NewX(key[:], iNonce[:])
+	require.Equal(ErrInvalidNonce, err, "NewX - 96 bit nonce")
+}
+
+func TestNewX(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		v = draftTestVectors[8]
+
+		subKey [32]byte
+	)
+	require.Len(v.iv, XNonceSize, "XChaCha20 test vector nonce")
+
+	c, err := NewX(v.key, v.iv)
+	require.NoError(err, "NewX")
+	out := make([]byte, len(v.stream))
+	c.KeyStream(out)
+	require.Equal(v.stream, out, "NewX: KeyStream")
+
+	// XChaCha20 is ChaCha20 keyed with HChaCha20(key, nonce[:16]), using
+	// the remaining 64 bits of the nonce.
+	err = HChaChaErr(v.key, v.iv[:HNonceSize], &subKey)
+	require.NoError(err, "HChaChaErr")
+	c, err = NewDJB(subKey[:], v.iv[HNonceSize:])
+	require.NoError(err, "NewDJB - subkey")
+	c.KeyStream(out)
+	require.Equal(v.stream, out, "NewDJB - subkey: KeyStream")
+}
+
+func TestMode(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
 
-		buf [2048]byte
+	for _, v := range []struct {
+		mode      Mode
+		str       string
+		nonceSize int
+	}{
+		{ModeDJB, "ChaCha20", NonceSize},
+		{ModeIETF, "ChaCha20-IETF", INonceSize},
+		{ModeXChaCha20, "XChaCha20", XNonceSize},
+	} {
+		c, err := New(key[:], nonce[:v.nonceSize])
+		require.NoError(err, "New")
+		require.Equal(v.mode, c.Mode(), "Mode")
+		require.Equal(v.str, c.Mode().String(), "Mode - String")
+		require.Equal(v.nonceSize, c.NonceSize(), "NonceSize")
+	}
 
-		expectedDigest = []byte{
-			0xcf, 0xd6, 0xe9, 0x49, 0x22, 0x5b, 0x85, 0x4f,
-			0xe0, 0x49, 0x46, 0x49, 0x1e, 0x69, 0x35, 0xff,
-			0x05, 0xff, 0x98, 0x3d, 0x15, 0x54, 0xbc, 0x88,
-			0x5b, 0xca, 0x0e, 0xc8, 0x08, 0x2d, 0xd5, 0xb8,
+	require.Equal("chacha20.Mode(42)", Mode(42).String(), "Mode - String (invalid)")
+}
+
+func TestXChaCha20XOR(t *testing.T) {
+	require := require.New(t)
+
+	v := draftTestVectors[8]
+	require.Len(v.iv, XNonceSize, "XChaCha20 test vector nonce")
+
+	out := make([]byte, len(v.stream))
+	err := XChaCha20XOR(out, out, v.iv, v.key)
+	require.NoError(err, "XChaCha20XOR")
+	require.Equal(v.stream, out, "XChaCha20XOR - output")
+
+	err = XChaCha20XOR(out, out, v.iv[:INonceSize], v.key)
+	require.Equal(ErrInvalidNonce, err, "XChaCha20XOR - 96 bit nonce")
+	err = XChaCha20XOR(out, out, v.iv, v.key[:16])
+	require.Equal(ErrInvalidKey, err, "XChaCha20XOR - 128 bit key")
+}
+
+func TestXORKeyStream(t *testing.T) {
+	require := require.New(t)
+
+	for _, v := range draftTestVectors {
+		if v.seekOffset != 0 {
+			continue
+		}
+
+		out := make([]byte, len(v.stream))
+		err := XORKeyStream(out, out, v.key, v.iv)
+		require.NoErrorf(err, "XORKeyStream: %s", v.name)
+		require.Equalf(v.stream, out, "XORKeyStream - output: %s", v.name)
+	}
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected, out [3*api.BlockSize + 5]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(expected[:])
+
+		for i := range out {
+			out[i] = 0
+		}
+		err = XORKeyStream(out[:], out[:], key[:], nonce[:nonceSize])
+		require.NoErrorf(err, "XORKeyStream: %d", nonceSize)
+		require.Equalf(expected, out, "XORKeyStream - output: %d", nonceSize)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		buf [2 * api.BlockSize]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(buf[:70])
+
+		// Struct copy (clone).
+		c2 := *c
+		require.True(c.Equal(&c2), "Equal - clone")
+		c2.KeyStream(buf[:1])
+		require.False(c.Equal(&c2), "Equal - clone advanced")
+		c.KeyStream(buf[:1])
+		require.True(c.Equal(&c2), "Equal - both advanced")
+
+		// Snapshot round trip.
+		snap, err := c.Snapshot()
+		require.NoError(err, "Snapshot")
+		c2p, err := RestoreCipher(key[:], snap)
+		require.NoError(err, "RestoreCipher")
+		require.True(c.Equal(c2p), "Equal - RestoreCipher")
+
+		// MarshalBinary round trip.
+		b, err := c.MarshalBinary()
+		require.NoError(err, "MarshalBinary")
+		var c3 Cipher
+		err = c3.UnmarshalBinary(b)
+		require.NoError(err, "UnmarshalBinary")
+		require.False(c.Equal(&c3), "Equal - no key")
+		err = c3.SetKey(key[:])
+		require.NoError(err, "SetKey")
+		require.True(c.Equal(&c3), "Equal - UnmarshalBinary")
+
+		// Different key, nonce, and counter.
+		key2 := key
+		key2[0] ^= 1
+		c4, err := New(key2[:], nonce[:nonceSize])
+		require.NoError(err, "New - key2")
+		c4.KeyStream(buf[:71])
+		require.False(c.Equal(c4), "Equal - different key")
+
+		nonce2 := nonce
+		nonce2[0] ^= 1
+		c4, err = New(key[:], nonce2[:nonceSize])
+		require.NoError(err, "New - nonce2")
+		c4.KeyStream(buf[:71])
+		require.False(c.Equal(c4), "Equal - different nonce")
+
+		c4, err = New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c4.KeyStream(buf[:7])
+		require.False(c.Equal(c4), "Equal - different position")
+	}
+
+	c, err := New(key[:], nonce[:NonceSize])
+	require.NoError(err, "New")
+	c2, err := New(key[:], nonce[:INonceSize])
+	require.NoError(err, "New")
+	require.False(c.Equal(c2), "Equal - different mode")
+}
+
+func TestStateMatrix(t *testing.T) {
+	require := require.New(t)
+
+	// RFC 7539 2.3.2 Test Vector for the ChaCha20 Block Function.
+	var (
+		key   [KeySize]byte
+		nonce = []byte{
+			0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a,
+			0x00, 0x00, 0x00, 0x00,
+		}
+		expected = [api.StateSize]uint32{
+			0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+			0x03020100, 0x07060504, 0x0b0a0908, 0x0f0e0d0c,
+			0x13121110, 0x17161514, 0x1b1a1918, 0x1f1e1d1c,
+			0x00000001, 0x09000000, 0x4a000000, 0x00000000,
+		}
+		block [api.BlockSize]byte
+	)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	c, err := NewIETF(key[:], nonce)
+	require.NoError(err, "NewIETF")
+	err = c.Seek(1)
+	require.NoError(err, "Seek")
+	require.Equal(expected, c.StateMatrix(), "StateMatrix - RFC 7539 2.3.2")
+
+	// The block counter tracks the key stream position.
+	c.KeyStream(block[:])
+	expected[12] = 2
+	require.Equal(expected, c.StateMatrix(), "StateMatrix - after 1 block")
+}
+
+func TestRatchet(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		pre, post, expected [3 * api.BlockSize]byte
+		newKey              [KeySize]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(pre[:])
+		c.KeyStream(newKey[:])
+
+		// The new key is the next 32 bytes of key stream, with the
+		// same nonce, starting from block 0.
+		c2, err := New(newKey[:], nonce[:nonceSize])
+		require.NoError(err, "New - ratcheted key")
+		c2.KeyStream(expected[:])
+
+		c, err = New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(pre[:])
+		err = c.Ratchet()
+		require.NoError(err, "Ratchet")
+		require.Equal(nonce[:nonceSize], c.Nonce(), "Nonce - after Ratchet")
+		c.KeyStream(post[:])
+		require.Equal(expected, post, "KeyStream - after Ratchet")
+		require.NotEqual(pre, post, "KeyStream - independent")
+	}
+
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	err = c.Seek(math.MaxUint32 - 1)
+	require.NoError(err, "Seek")
+	c.KeyStream(pre[:api.BlockSize-KeySize+1])
+	err = c.Ratchet()
+	require.Equal(ErrKeyStreamExhausted, err, "Ratchet - exhausted")
+}
+
+func TestSubStream(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected, out, out2 [2*api.BlockSize + 3]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+
+		for _, domain := range []uint32{1, 2, 0xdeadbeef} {
+			// Each domain is a fixed region of the original key stream.
+			parent := *c
+			err = parent.Seek(uint64(domain) << 32)
+			require.NoError(err, "Seek")
+			parent.KeyStream(expected[:])
+
+			sc := c.SubStream(domain)
+			require.Equal(ModeIETF, sc.Mode(), "SubStream - mode")
+			sc.KeyStream(out[:])
+			require.Equalf(expected, out, "SubStream: %d, %d", nonceSize, domain)
+
+			// Distinct domains are independent.
+			c.SubStream(domain + 1).KeyStream(out2[:])
+			require.NotEqual(out, out2, "SubStream - distinct domains")
+
+			// The derived nonce is domain || nonce.
+			if nonceSize == NonceSize {
+				c2, err := NewIETF(key[:], sc.Nonce())
+				require.NoError(err, "NewIETF - derived nonce")
+				c2.KeyStream(out2[:])
+				require.Equal(expected, out2, "SubStream - derived nonce")
+			}
+
+			// A SubStream can be restored from a snapshot with the
+			// original key, except for XChaCha20, which is keyed with
+			// the derived subkey.
+			err = sc.Seek(0)
+			require.NoError(err, "Seek - SubStream")
+			sc.KeyStream(out2[:5])
+			snap, err := sc.Snapshot()
+			if nonceSize == NonceSize {
+				require.NoError(err, "Snapshot - SubStream")
+				restored, err := RestoreCipher(key[:], snap)
+				require.NoError(err, "RestoreCipher - SubStream")
+				restored.KeyStream(out2[5:])
+				require.Equal(expected, out2, "RestoreCipher - SubStream")
+			} else {
+				require.Equal(ErrSnapshotUnsupported, err, "Snapshot - XChaCha20 SubStream")
+				_, err = sc.MarshalBinary()
+				require.Equal(ErrSnapshotUnsupported, err, "MarshalBinary - XChaCha20 SubStream")
+			}
+
+			// A domain can not run into the next one.
+			err = sc.Seek(math.MaxUint32 - 1)
+			require.NoError(err, "Seek")
+			require.Panics(func() {
+				sc.KeyStream(out[:])
+			}, "SubStream - domain exhausted")
 		}
+	}
+
+	c, err := NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	require.Panics(func() {
+		c.SubStream(1)
+	}, "SubStream - IETF")
+}
+
+func TestReset(t *testing.T) {
+	require := require.New(t)
+
+	const usedAfterReset = "chacha20: Cipher used after Reset without being re-keyed"
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+		buf   [api.BlockSize]byte
+		words [4]uint32
 	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
 
 	c, err := New(key[:], nonce[:])
 	require.NoError(err, "New")
+	c.KeyStream(buf[:7])
 
-	h := sha512.New512_256()
-	for i := 1; i <= 2048; i++ {
-		c.KeyStream(buf[:i])
-		_, _ = h.Write(buf[:i])
+	// The unconsumed tail of the block is only held in the buffer, and
+	// KeyStreamAt must not disturb it.
+	saved := c.buf
+	err = c.KeyStreamAt(buf[:], 1000)
+	require.NoError(err, "KeyStreamAt")
+	require.Equal(saved, c.buf, "KeyStreamAt - buffered key stream")
+
+	c.Reset()
+	c.Reset() // Multiple calls are harmless.
+
+	// Reset must clear any buffered key stream.
+	require.Equal([api.BlockSize]byte{}, c.buf, "Reset - buffered key stream")
+	require.Equal([api.StateSize]uint32{}, c.StateMatrix(), "Reset - state")
+
+	for _, v := range []struct {
+		name string
+		fn   func()
+	}{
+		{"XORKeyStream", func() { c.XORKeyStream(buf[:], buf[:]) }},
+		{"XORKeyStreamN", func() { _, _ = c.XORKeyStreamN(buf[:], buf[:]) }},
+		{"XORKeyStreamAt", func() { _ = c.XORKeyStreamAt(buf[:], buf[:], 0) }},
+		{"XORKeyStreamWithCounter", func() { c.XORKeyStreamWithCounter(buf[:], buf[:], 0) }},
+		{"KeyStream", func() { c.KeyStream(buf[:]) }},
+		{"KeyStreamAt", func() { _ = c.KeyStreamAt(buf[:], 0) }},
+		{"KeyStreamWords", func() { _ = c.KeyStreamWords(words[:]) }},
+		{"KeyStreamErr", func() { _ = c.KeyStreamErr(buf[:]) }},
+		{"KeyStreamBlocks", func() { _ = c.KeyStreamBlocks(buf[:], 1) }},
+		{"NextKeyStream", func() { _ = c.NextKeyStream(len(buf)) }},
+		{"Ratchet", func() { _ = c.Ratchet() }},
+		{"Shuffle", func() { c.Shuffle(len(buf), func(i, j int) {}) }},
+		{"Seek", func() { _ = c.Seek(0) }},
+		{"Discard", func() { _ = c.Discard(1) }},
+		{"FirstBlock", func() { _ = c.FirstBlock() }},
+		{"SubStream", func() { _ = c.SubStream(0) }},
+	} {
+		require.PanicsWithValue(usedAfterReset, v.fn, v.name)
 	}
 
-	digest := h.Sum(nil)
+	// Re-keying makes the instance usable again.
+	err = c.ReKey(key[:], nonce[:])
+	require.NoError(err, "ReKey")
+	require.NotPanics(func() { c.KeyStream(buf[:]) }, "KeyStream - after ReKey")
 
-	require.Equal(expectedDigest, digest, "KeyStream digest matches")
+	// UnmarshalBinary does not restore the key, so SetKey is required.
+	snap, err := c.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	var c2 Cipher
+	err = c2.UnmarshalBinary(snap)
+	require.NoError(err, "UnmarshalBinary")
+	require.PanicsWithValue(usedAfterReset, func() { c2.KeyStream(buf[:]) }, "KeyStream - before SetKey")
+	err = c2.SetKey(key[:])
+	require.NoError(err, "SetKey")
+	require.NotPanics(func() { c2.KeyStream(buf[:]) }, "KeyStream - after SetKey")
 }
 
-func doTestVectors(t *testing.T) {
-	for _, v := range draftTestVectors {
-		t.Run(v.name, func(t *testing.T) {
-			require := require.New(t)
-			c, err := New(v.key, v.iv)
-			require.NoError(err, "New")
+func TestAlignUp(t *testing.T) {
+	require := require.New(t)
 
-			if v.seekOffset != 0 {
-				err = c.Seek(v.seekOffset)
-				require.NoErrorf(err, "Seek(%d)", v.seekOffset)
+	require.Equal(api.BlockSize, BlockSize, "BlockSize")
+	for _, v := range []struct {
+		n, expected int
+	}{
+		{0, 0},
+		{1, 64},
+		{63, 64},
+		{64, 64},
+		{65, 128},
+		{1000, 1024},
+		{1024, 1024},
+	} {
+		require.Equalf(v.expected, AlignUp(v.n), "AlignUp(%d)", v.n)
+	}
+}
+
+func TestKeyNonceOverlap(t *testing.T) {
+	require := require.New(t)
+
+	var b [KeySize + XNonceSize]byte
+	_, err := rand.Read(b[:])
+	require.NoError(err, "rand.Read")
+
+	// Adjacent slices of the same array are fine.
+	key := b[:KeySize]
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		_, err = New(key, b[KeySize:KeySize+nonceSize])
+		require.NoErrorf(err, "New - adjacent, nonce size: %d", nonceSize)
+	}
+
+	for _, off := range []int{0, 1, KeySize - NonceSize, KeySize - 1} {
+		for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+			nonce := b[off : off+nonceSize]
+
+			_, err = New(key, nonce)
+			require.Equalf(ErrKeyNonceOverlap, err, "New - offset: %d, nonce size: %d", off, nonceSize)
+
+			err = XORKeyStream(nil, nil, key, nonce)
+			require.Equalf(ErrKeyNonceOverlap, err, "XORKeyStream - offset: %d, nonce size: %d", off, nonceSize)
+		}
+	}
+
+	c, err := New(key, b[KeySize:KeySize+NonceSize])
+	require.NoError(err, "New")
+	err = c.ReKey(key, b[8:8+INonceSize])
+	require.Equal(ErrKeyNonceOverlap, err, "ReKey")
+	require.Equal(ModeDJB, c.Mode(), "ReKey - mode unaltered")
+}
+
+func TestActiveImplementation(t *testing.T) {
+	require := require.New(t)
+
+	oldImpl := activeImpl
+	defer func() {
+		activeImpl = oldImpl
+	}()
+
+	for _, v := range supportedImpls {
+		activeImpl = v
+		require.Equal(v.Name(), ActiveImplementation(), "ActiveImplementation")
+		require.Equalf(v == ref.Impl, IsReferenceImpl(), "IsReferenceImpl: %s", v.Name())
+	}
+
+	// The reference implementation is always registered last.
+	require.True(IsReferenceImpl(), "IsReferenceImpl - last")
+}
+
+func TestInvalidSizes(t *testing.T) {
+	var (
+		key    [KeySize + 1]byte
+		nonce  [XNonceSize + 1]byte
+		good   [KeySize]byte
+		goodIV [NonceSize]byte
+	)
+
+	type ctorFn func(key, nonce []byte) error
+	wrap := func(fn func(key, nonce []byte) (*Cipher, error)) ctorFn {
+		return func(key, nonce []byte) error {
+			_, err := fn(key, nonce)
+			return err
+		}
+	}
+	ctors := []struct {
+		name      string
+		fn        ctorFn
+		nonceSize int
+	}{
+		{"New", wrap(New), NonceSize},
+		{"NewDJB", wrap(NewDJB), NonceSize},
+		{"NewIETF", wrap(NewIETF), INonceSize},
+		{"NewX", wrap(NewX), XNonceSize},
+		{"XORKeyStream", func(key, nonce []byte) error {
+			return XORKeyStream(nil, nil, key, nonce)
+		}, NonceSize},
+		{"ReKey", func(key, nonce []byte) error {
+			c, err := New(good[:], goodIV[:])
+			if err != nil {
+				panic(err)
 			}
+			return c.ReKey(key, nonce)
+		}, NonceSize},
+	}
 
-			out := make([]byte, len(v.stream))
-			c.XORKeyStream(out, out)
-			require.EqualValues(v.stream, out, "XORKeyStream")
+	for _, v := range ctors {
+		t.Run(v.name, func(t *testing.T) {
+			require := require.New(t)
 
-			// Ensure that KeyStream tramples over dst.
-			_, err = rand.Read(out)
-			require.NoError(err, "rand.Read")
+			for _, sz := range []int{0, 16, KeySize - 1, KeySize + 1} {
+				err := v.fn(key[:sz], nonce[:v.nonceSize])
+				require.Equalf(ErrInvalidKey, err, "key size: %d", sz)
+			}
+			for _, sz := range []int{0, 7, 11, 13, 16, 23, 25} {
+				err := v.fn(key[:KeySize], nonce[:sz])
+				require.Equalf(ErrInvalidNonce, err, "nonce size: %d", sz)
+			}
 
-			err = c.Seek(v.seekOffset)
-			require.NoErrorf(err, "Seek(%d) - resetting", v.seekOffset)
-			c.KeyStream(out)
-			require.EqualValues(v.stream, out, "KeyStream")
+			// Key errors take precedence.
+			err := v.fn(key[:1], nonce[:1])
+			require.Equal(ErrInvalidKey, err, "both invalid")
 		})
 	}
+
+	t.Run("HChaChaErr", func(t *testing.T) {
+		require := require.New(t)
+
+		var dst, zero [32]byte
+		for _, sz := range []int{0, 16, KeySize - 1, KeySize + 1} {
+			err := HChaChaErr(key[:sz], nonce[:HNonceSize], &dst)
+			require.Equalf(ErrInvalidKey, err, "key size: %d", sz)
+		}
+		for _, sz := range []int{0, 8, 12, HNonceSize - 1, HNonceSize + 1, XNonceSize} {
+			err := HChaChaErr(key[:KeySize], nonce[:sz], &dst)
+			require.Equalf(ErrInvalidNonce, err, "nonce size: %d", sz)
+		}
+		require.Equal(zero, dst, "dst - untouched on error")
+
+		// Key errors take precedence.
+		err := HChaChaErr(key[:1], nonce[:1], &dst)
+		require.Equal(ErrInvalidKey, err, "both invalid")
+	})
 }
 
 func BenchmarkChaCha20(b *testing.B) {
@@ -505,6 +1876,61 @@ func BenchmarkChaCha20(b *testing.B) {
 	}
 }
 
+func BenchmarkSeek(b *testing.B) {
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+		tmp   [1]byte
+	)
+
+	for _, v := range supportedImpls {
+		benchWithImpl := func(b *testing.B, impl api.Implementation) {
+			oldImpl := activeImpl
+			defer func() {
+				activeImpl = oldImpl
+			}()
+
+			activeImpl = impl
+			for _, vv := range []struct {
+				name      string
+				nonceSize int
+				offsets   []uint64
+			}{
+				{"DJB", NonceSize, []uint64{0, 1, 1 << 32, math.MaxUint64 - 1}},
+				{"IETF", INonceSize, []uint64{0, 1, math.MaxUint32 - 2, math.MaxUint32 - 1}},
+			} {
+				c, err := New(key[:], nonce[:vv.nonceSize])
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				// Seek alone, and Seek followed by generating the first
+				// byte at the new position, which is the random access
+				// cost.
+				b.Run(vv.name, func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if err := c.Seek(vv.offsets[i%len(vv.offsets)]); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+				b.Run(vv.name+"+1", func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if err := c.Seek(vv.offsets[i%len(vv.offsets)]); err != nil {
+							b.Fatal(err)
+						}
+						c.XORKeyStream(tmp[:], tmp[:])
+					}
+				})
+			}
+		}
+
+		b.Run(v.Name(), func(b *testing.B) {
+			benchWithImpl(b, v)
+		})
+	}
+}
+
 func doBenchN(b *testing.B, n int) {
 	var (
 		key   [KeySize]byte
@@ -523,3 +1949,73 @@ func doBenchN(b *testing.B, n int) {
 		c.XORKeyStream(s, s)
 	}
 }
+
+func BenchmarkChaCha20Bytewise(b *testing.B) {
+	for _, v := range supportedImpls {
+		b.Run(v.Name(), func(b *testing.B) {
+			oldImpl := activeImpl
+			defer func() {
+				activeImpl = oldImpl
+			}()
+
+			activeImpl = v
+			doBenchBytewise(b, 4096)
+		})
+	}
+}
+
+func BenchmarkXORKeyStreamBuffered(b *testing.B) {
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+		s     [api.BlockSize - 1]byte
+	)
+
+	// Calls smaller than a block, mostly served from the buffered key
+	// stream left over from the previous call.
+	for _, n := range []int{1, 3, 7, 8, 16, 32, 63} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			c, err := New(key[:], nonce[:])
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.KeyStream(s[:1])
+			b.SetBytes(int64(n))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.XORKeyStream(s[:n], s[:n])
+			}
+		})
+	}
+
+	// The same amount of data as 1 byte calls, and as a single call, so
+	// that the per-byte cost of small calls can be compared.
+	b.Run("4096/bytewise", func(b *testing.B) {
+		doBenchBytewise(b, 4096)
+	})
+	b.Run("4096/bulk", func(b *testing.B) {
+		doBenchN(b, 4096)
+	})
+}
+
+func doBenchBytewise(b *testing.B, n int) {
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+	)
+
+	s := make([]byte, n)
+	c, err := New(key[:], nonce[:])
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(n))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range s {
+			c.XORKeyStream(s[j:j+1], s[j:j+1])
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package core exposes the raw ChaCha20 block function, for building other
+// primitives.  Most users should use the chacha20 package instead.
+package core // import "github.com/fengxuway/chacha20/core"
+
+import (
+	"github.com/fengxuway/chacha20/internal/api"
+	"github.com/fengxuway/chacha20/internal/hardware"
+	"github.com/fengxuway/chacha20/internal/ref"
+)
+
+const (
+	// BlockSize is the ChaCha20 block size in bytes.
+	BlockSize = api.BlockSize
+
+	// KeySize is the ChaCha20 key size in bytes.
+	KeySize = 32
+
+	// NonceSize is the IETF ChaCha20 nonce size in bytes.
+	NonceSize = 12
+)
+
+var (
+	supportedImpls []api.Implementation
+	activeImpl     api.Implementation
+)
+
+// Block sets out to the IETF ChaCha20 block for the provided key, block
+// counter, and nonce.  Only 20 rounds are supported, and Block will panic
+// if any other value is passed as rounds.
+func Block(out *[BlockSize]byte, key *[KeySize]byte, counter uint32, nonce *[NonceSize]byte, rounds int) {
+	if rounds != 20 {
+		panic("chacha20/core: only 20 rounds are supported")
+	}
+
+	var x [api.StateSize]uint32
+	api.InitState(&x, key[:])
+	x[12] = counter
+	api.LoadWords(x[13:16], nonce[:])
+
+	activeImpl.Blocks(&x, out[:], nil, 1)
+
+	for i := range x {
+		x[i] = 0
+	}
+}
+
+func init() {
+	supportedImpls = hardware.Register(supportedImpls)
+	supportedImpls = ref.Register(supportedImpls)
+	activeImpl = supportedImpls[0]
+}
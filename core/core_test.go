@@ -0,0 +1,84 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20"
+)
+
+func TestBlock(t *testing.T) {
+	oldImpl := activeImpl
+	defer func() {
+		activeImpl = oldImpl
+	}()
+
+	for _, v := range supportedImpls {
+		t.Run(v.Name(), func(t *testing.T) {
+			activeImpl = v
+			doTestBlock(t)
+		})
+	}
+}
+
+func doTestBlock(t *testing.T) {
+	require := require.New(t)
+
+	// RFC 7539 2.3.2 Test Vector for the ChaCha20 Block Function.
+	var (
+		key   [KeySize]byte
+		nonce = [NonceSize]byte{
+			0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a,
+			0x00, 0x00, 0x00, 0x00,
+		}
+		out [BlockSize]byte
+	)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	expected, _ := hex.DecodeString(
+		"10f1e7e4d13b5915500fdd1fa32071c4c7d1f4c733c068030422aa9ac3d46c4e" +
+			"d2826446079faa0914c2d705d98b02a2b5129cd1de164eb9cbd083e8a2503c4e",
+	)
+
+	Block(&out, &key, 1, &nonce, 20)
+	require.Equal(expected, out[:], "Block - RFC 7539 2.3.2")
+
+	// Cross check against the stream cipher, including the largest block
+	// counter, which the IETF variant of the stream cipher will not generate,
+	// by using the original variant (with nonce[0:4] as the high half of the
+	// 64 bit counter).
+	for _, ctr := range []uint32{0, 1, 0x12345678, math.MaxUint32} {
+		Block(&out, &key, ctr, &nonce, 20)
+
+		c, err := chacha20.NewDJB(key[:], nonce[4:])
+		require.NoError(err, "NewDJB")
+		err = c.Seek(uint64(0x09000000)<<32 | uint64(ctr))
+		require.NoError(err, "Seek")
+		var block [BlockSize]byte
+		c.KeyStream(block[:])
+		require.Equalf(block, out, "Block - counter: %d", ctr)
+	}
+
+	require.Panics(func() {
+		Block(&out, &key, 0, &nonce, 12)
+	}, "Block - 12 rounds")
+}
@@ -5,5 +5,5 @@ go 1.12
 require (
 	github.com/stretchr/testify v1.4.0
 	gitlab.com/yawning/chacha20.git v0.0.0-20190903091407-6d1cb28dc72c // indirect
-	golang.org/x/sys v0.0.0-20190902133755-9109b7679e13
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f
 )
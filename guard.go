@@ -0,0 +1,125 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/sha512"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrNonceReuse is the error returned by a NonceGuard when a key and
+	// nonce pair is reused.
+	ErrNonceReuse = errors.New("chacha20: nonce reused with the same key")
+
+	// ErrInvalidGuardCapacity is the error returned when the NonceGuard
+	// capacity is invalid.
+	ErrInvalidGuardCapacity = errors.New("chacha20: nonce guard capacity must be positive")
+)
+
+// NonceGuard is an opt-in debugging aid that detects key and nonce pairs
+// being used more than once (eg: by connection pool code that re-keys
+// instances), by remembering the most recently used pairs.
+//
+// Tradeoffs:
+//
+//   - Only the last capacity pairs are remembered, so reuse separated by
+//     more than capacity other pairs goes undetected.
+//   - Each remembered pair costs a 32 byte digest plus map overhead.  The key
+//     itself is never retained, only SHA-512/256(key || nonce).
+//   - There are no false positives (short of a SHA-512/256 collision), unlike
+//     a Bloom filter, at the cost of more memory per entry.
+//   - Detection is limited to a single NonceGuard instance, and does not
+//     survive process restarts.
+//
+// A NonceGuard is safe for concurrent use.
+type NonceGuard struct {
+	mu sync.Mutex
+
+	seen  map[[32]byte]struct{}
+	order [][32]byte
+	next  int
+}
+
+// NewNonceGuard returns a new NonceGuard that remembers up to capacity key
+// and nonce pairs.
+func NewNonceGuard(capacity int) (*NonceGuard, error) {
+	if capacity <= 0 {
+		return nil, ErrInvalidGuardCapacity
+	}
+
+	return &NonceGuard{
+		seen:  make(map[[32]byte]struct{}, capacity),
+		order: make([][32]byte, 0, capacity),
+	}, nil
+}
+
+// Check records the key and nonce pair, and returns ErrNonceReuse if it has
+// been seen before.
+func (g *NonceGuard) Check(key, nonce []byte) error {
+	if len(key) != KeySize {
+		return ErrInvalidKey
+	}
+	switch len(nonce) {
+	case NonceSize, INonceSize, XNonceSize:
+	default:
+		return ErrInvalidNonce
+	}
+
+	var b [KeySize + XNonceSize]byte
+	copy(b[:], key)
+	copy(b[KeySize:], nonce)
+	digest := sha512.Sum512_256(b[:KeySize+len(nonce)])
+	for i := range b {
+		b[i] = 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.seen[digest]; ok {
+		return ErrNonceReuse
+	}
+	if len(g.order) < cap(g.order) {
+		g.order = append(g.order, digest)
+	} else {
+		delete(g.seen, g.order[g.next])
+		g.order[g.next] = digest
+		g.next = (g.next + 1) % len(g.order)
+	}
+	g.seen[digest] = struct{}{}
+
+	return nil
+}
+
+// ReKey checks the key and nonce pair, and reinitializes c with them if they
+// have not been seen before.
+func (g *NonceGuard) ReKey(c *Cipher, key, nonce []byte) error {
+	if err := g.Check(key, nonce); err != nil {
+		return err
+	}
+	return c.ReKey(key, nonce)
+}
+
+// AcquireCipher checks the key and nonce pair, and returns an instance from
+// the shared pool (see AcquireCipher) if they have not been seen before.
+func (g *NonceGuard) AcquireCipher(key, nonce []byte) (*Cipher, error) {
+	if err := g.Check(key, nonce); err != nil {
+		return nil, err
+	}
+	return AcquireCipher(key, nonce)
+}
@@ -0,0 +1,75 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonceGuard(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key, key2 [KeySize]byte
+		nonce     [XNonceSize]byte
+	)
+	key2[0] = 1
+
+	_, err := NewNonceGuard(0)
+	require.Equal(ErrInvalidGuardCapacity, err, "NewNonceGuard - 0")
+
+	g, err := NewNonceGuard(4)
+	require.NoError(err, "NewNonceGuard")
+
+	err = g.Check(key[:], nonce[:NonceSize])
+	require.NoError(err, "Check")
+	err = g.Check(key[:], nonce[:NonceSize])
+	require.Equal(ErrNonceReuse, err, "Check - reused")
+	err = g.Check(key2[:], nonce[:NonceSize])
+	require.NoError(err, "Check - different key")
+	err = g.Check(key[:], nonce[:INonceSize])
+	require.NoError(err, "Check - different nonce size")
+
+	err = g.Check(key[:1], nonce[:NonceSize])
+	require.Equal(ErrInvalidKey, err, "Check - invalid key")
+	err = g.Check(key[:], nonce[:1])
+	require.Equal(ErrInvalidNonce, err, "Check - invalid nonce")
+
+	// Reset/ReKey path.
+	var c Cipher
+	nonce[0] = 1
+	err = g.ReKey(&c, key[:], nonce[:])
+	require.NoError(err, "ReKey")
+	c.Reset()
+	err = g.ReKey(&c, key[:], nonce[:])
+	require.Equal(ErrNonceReuse, err, "ReKey - reused")
+
+	// Pooled path.
+	nonce[0] = 2
+	pc, err := g.AcquireCipher(key[:], nonce[:])
+	require.NoError(err, "AcquireCipher")
+	ReleaseCipher(pc)
+	_, err = g.AcquireCipher(key[:], nonce[:])
+	require.Equal(ErrNonceReuse, err, "AcquireCipher - reused")
+
+	// Only the most recent pairs are remembered.
+	require.Len(g.seen, 4, "seen - bounded")
+	nonce[0] = 0
+	err = g.Check(key[:], nonce[:NonceSize])
+	require.NoError(err, "Check - evicted")
+}
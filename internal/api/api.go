@@ -16,6 +16,8 @@
 // Package api provides the ChaCha20 implementation abstract interface.
 package api
 
+import "encoding/binary"
+
 const (
 	// BlockSize is the size of a ChaCha20 block in bytes.
 	BlockSize = 64
@@ -57,3 +59,30 @@ type Implementation interface {
 	// Note: `dst` is guaranteed to be HashSize bytes.
 	HChaCha(key, nonce []byte, dst []byte)
 }
+
+// InitState sets the ChaCha constant and key words (x[0]...x[11]) of the
+// state, leaving the counter and nonce words untouched.  key must be at
+// least 32 bytes.
+func InitState(x *[StateSize]uint32, key []byte) {
+	x[0] = Sigma0
+	x[1] = Sigma1
+	x[2] = Sigma2
+	x[3] = Sigma3
+	LoadWords(x[4:12], key[:32])
+}
+
+// LoadWords sets each element of dst to the corresponding little endian 32
+// bit word of src, which must be at least 4 * len(dst) bytes.
+func LoadWords(dst []uint32, src []byte) {
+	for i := range dst {
+		dst[i] = binary.LittleEndian.Uint32(src[i*4:])
+	}
+}
+
+// StoreWords sets dst to the little endian encoding of each element of src.
+// dst must be at least 4 * len(src) bytes.
+func StoreWords(dst []byte, src []uint32) {
+	for i, v := range src {
+		binary.LittleEndian.PutUint32(dst[i*4:], v)
+	}
+}
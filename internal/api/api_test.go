@@ -0,0 +1,65 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// leWord decodes a little endian word with shifts, independent of the
+// byte order of the host.
+func leWord(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func TestLoadStore(t *testing.T) {
+	require := require.New(t)
+
+	var key [32]byte
+	for i := range key {
+		key[i] = byte(i*7 + 1)
+	}
+
+	var x, expected [StateSize]uint32
+	for i := range x {
+		x[i] = 0xdeadbeef
+	}
+	expected = x
+	expected[0], expected[1], expected[2], expected[3] = Sigma0, Sigma1, Sigma2, Sigma3
+	for i := 0; i < 8; i++ {
+		expected[4+i] = leWord(key[i*4:])
+	}
+	InitState(&x, key[:])
+	require.Equal(expected, x, "InitState")
+	require.Equal(uint32(0x322b241d), x[5], "InitState - byte order")
+
+	var words [3]uint32
+	LoadWords(words[:], key[4:16])
+	require.Equal(x[5:8], words[:], "LoadWords")
+
+	var b [12]byte
+	StoreWords(b[:], words[:])
+	require.Equal(key[4:16], b[:], "StoreWords")
+
+	require.Panics(func() {
+		LoadWords(words[:], key[:11])
+	}, "LoadWords - short src")
+	require.Panics(func() {
+		StoreWords(b[:11], words[:])
+	}, "StoreWords - short dst")
+}
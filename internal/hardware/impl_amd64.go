@@ -23,6 +23,9 @@ import (
 	"github.com/fengxuway/chacha20/internal/api"
 )
 
+//go:noescape
+func blocksAVX512(s *[api.StateSize]uint32, in, out []byte)
+
 //go:noescape
 func blocksAVX2(s *[api.StateSize]uint32, in, out []byte)
 
@@ -70,7 +73,30 @@ func blockWrapper(fn func(*[api.StateSize]uint32, []byte, []byte)) func(*[api.St
 	}
 }
 
+// blocksAVX512Hybrid processes as many blocks as possible 16 at a time with
+// AVX-512, and the remainder with AVX2.
+func blocksAVX512Hybrid(x *[api.StateSize]uint32, in, out []byte) {
+	const groupSize = 16 * api.BlockSize
+
+	n := len(in) / groupSize * groupSize
+	if n > 0 {
+		blocksAVX512(x, in[:n], out[:n])
+	}
+	if n < len(in) {
+		blocksAVX2(x, in[n:], out[n:])
+	}
+}
+
 func init() {
+	// The AVX-512 backend must be enabled with the chacha20_avx512 build
+	// tag, otherwise AVX2 is the default.
+	if avx512OptIn && cpu.X86.HasAVX512F && cpu.X86.HasAVX512BW && cpu.X86.HasAVX2 {
+		hardwareImpls = append(hardwareImpls, &implAmd64{
+			name:      "amd64_avx512",
+			blocksFn:  blockWrapper(blocksAVX512Hybrid),
+			hChaChaFn: hChaChaAVX2,
+		})
+	}
 	if cpu.X86.HasAVX2 {
 		hardwareImpls = append(hardwareImpls, &implAmd64{
 			name:      "amd64_avx2",
@@ -0,0 +1,184 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !noasm
+
+#include "textflag.h"
+
+DATA ·chacha_avx512_incs<>+0x00(SB)/4, $0
+DATA ·chacha_avx512_incs<>+0x04(SB)/4, $1
+DATA ·chacha_avx512_incs<>+0x08(SB)/4, $2
+DATA ·chacha_avx512_incs<>+0x0c(SB)/4, $3
+DATA ·chacha_avx512_incs<>+0x10(SB)/4, $4
+DATA ·chacha_avx512_incs<>+0x14(SB)/4, $5
+DATA ·chacha_avx512_incs<>+0x18(SB)/4, $6
+DATA ·chacha_avx512_incs<>+0x1c(SB)/4, $7
+DATA ·chacha_avx512_incs<>+0x20(SB)/4, $8
+DATA ·chacha_avx512_incs<>+0x24(SB)/4, $9
+DATA ·chacha_avx512_incs<>+0x28(SB)/4, $10
+DATA ·chacha_avx512_incs<>+0x2c(SB)/4, $11
+DATA ·chacha_avx512_incs<>+0x30(SB)/4, $12
+DATA ·chacha_avx512_incs<>+0x34(SB)/4, $13
+DATA ·chacha_avx512_incs<>+0x38(SB)/4, $14
+DATA ·chacha_avx512_incs<>+0x3c(SB)/4, $15
+DATA ·chacha_avx512_incs<>+0x40(SB)/4, $1
+GLOBL ·chacha_avx512_incs<>(SB), (NOPTR+RODATA), $68
+
+// QUARTERROUND is the ChaCha quarter round applied to each 32 bit lane of
+// the ZMM registers a, b, c, and d.
+#define QUARTERROUND(a, b, c, d) \
+	VPADDD b, a, a;    \
+	VPXORD a, d, d;    \
+	VPROLD $16, d, d;  \
+	VPADDD d, c, c;    \
+	VPXORD c, b, b;    \
+	VPROLD $12, b, b;  \
+	VPADDD b, a, a;    \
+	VPXORD a, d, d;    \
+	VPROLD $8, d, d;   \
+	VPADDD d, c, c;    \
+	VPXORD c, b, b;    \
+	VPROLD $7, b, b
+
+// TRANSPOSE_4X4 transposes the 32 bit words within each 128 bit lane of the
+// ZMM registers a, b, c, and d, using t0-t3 as scratch.
+#define TRANSPOSE_4X4(a, b, c, d, t0, t1, t2, t3) \
+	VPUNPCKLDQ  b, a, t0; \
+	VPUNPCKHDQ  b, a, t1; \
+	VPUNPCKLDQ  d, c, t2; \
+	VPUNPCKHDQ  d, c, t3; \
+	VPUNPCKLQDQ t2, t0, a; \
+	VPUNPCKHQDQ t2, t0, b; \
+	VPUNPCKLQDQ t3, t1, c; \
+	VPUNPCKHQDQ t3, t1, d
+
+// XOR_STORE_4 transposes the 128 bit lanes of the ZMM registers a, b, c,
+// and d (using t0-t7 as scratch), XORs the resulting 4 blocks with the
+// input at SI, and writes them to the output at DI.  The blocks are written
+// at off, off+256, off+512, and off+768 bytes.
+#define XOR_STORE_4(a, b, c, d, off, t0, t1, t2, t3, t4, t5, t6, t7) \
+	VSHUFI32X4 $0x44, b, a, t0;     \
+	VSHUFI32X4 $0x44, d, c, t1;     \
+	VSHUFI32X4 $0xee, b, a, t2;     \
+	VSHUFI32X4 $0xee, d, c, t3;     \
+	VSHUFI32X4 $0x88, t1, t0, t4;   \
+	VSHUFI32X4 $0xdd, t1, t0, t5;   \
+	VSHUFI32X4 $0x88, t3, t2, t6;   \
+	VSHUFI32X4 $0xdd, t3, t2, t7;   \
+	VPXORD     off(SI), t4, t4;     \
+	VPXORD     off+256(SI), t5, t5; \
+	VPXORD     off+512(SI), t6, t6; \
+	VPXORD     off+768(SI), t7, t7; \
+	VMOVDQU32  t4, off(DI);         \
+	VMOVDQU32  t5, off+256(DI);     \
+	VMOVDQU32  t6, off+512(DI);     \
+	VMOVDQU32  t7, off+768(DI)
+
+// func blocksAVX512(s *[api.StateSize]uint32, in, out []byte)
+TEXT ·blocksAVX512(SB), NOSPLIT|NOFRAME, $0-56
+	// This processes 16 blocks at a time, with each ZMM register holding
+	// the same state word for all 16 blocks.  Any trailing partial group
+	// of blocks is left to the caller.
+	MOVQ s+0(FP), AX
+	MOVQ in+8(FP), SI
+	MOVQ in_len+16(FP), CX
+	MOVQ out+32(FP), DI
+
+	SHRQ $10, CX
+	JZ   done
+
+	VMOVDQU32    ·chacha_avx512_incs<>(SB), Z18
+	VPBROADCASTD ·chacha_avx512_incs<>+0x40(SB), Z19
+
+loop:
+	VPBROADCASTD 0(AX), Z0
+	VPBROADCASTD 4(AX), Z1
+	VPBROADCASTD 8(AX), Z2
+	VPBROADCASTD 12(AX), Z3
+	VPBROADCASTD 16(AX), Z4
+	VPBROADCASTD 20(AX), Z5
+	VPBROADCASTD 24(AX), Z6
+	VPBROADCASTD 28(AX), Z7
+	VPBROADCASTD 32(AX), Z8
+	VPBROADCASTD 36(AX), Z9
+	VPBROADCASTD 40(AX), Z10
+	VPBROADCASTD 44(AX), Z11
+	VPBROADCASTD 48(AX), Z12
+	VPBROADCASTD 52(AX), Z13
+	VPBROADCASTD 56(AX), Z14
+	VPBROADCASTD 60(AX), Z15
+
+	// Per-block 64 bit counter, with the carry propagated into word 13.
+	VPADDD  Z18, Z12, Z12
+	VPCMPUD $1, Z18, Z12, K1
+	VPADDD  Z19, Z13, K1, Z13
+	VMOVDQA32 Z12, Z16
+	VMOVDQA32 Z13, Z17
+
+	MOVQ $10, DX
+
+rounds:
+	QUARTERROUND(Z0, Z4, Z8, Z12)
+	QUARTERROUND(Z1, Z5, Z9, Z13)
+	QUARTERROUND(Z2, Z6, Z10, Z14)
+	QUARTERROUND(Z3, Z7, Z11, Z15)
+	QUARTERROUND(Z0, Z5, Z10, Z15)
+	QUARTERROUND(Z1, Z6, Z11, Z12)
+	QUARTERROUND(Z2, Z7, Z8, Z13)
+	QUARTERROUND(Z3, Z4, Z9, Z14)
+	DECQ DX
+	JNZ  rounds
+
+	VPADDD.BCST 0(AX), Z0, Z0
+	VPADDD.BCST 4(AX), Z1, Z1
+	VPADDD.BCST 8(AX), Z2, Z2
+	VPADDD.BCST 12(AX), Z3, Z3
+	VPADDD.BCST 16(AX), Z4, Z4
+	VPADDD.BCST 20(AX), Z5, Z5
+	VPADDD.BCST 24(AX), Z6, Z6
+	VPADDD.BCST 28(AX), Z7, Z7
+	VPADDD.BCST 32(AX), Z8, Z8
+	VPADDD.BCST 36(AX), Z9, Z9
+	VPADDD.BCST 40(AX), Z10, Z10
+	VPADDD.BCST 44(AX), Z11, Z11
+	VPADDD      Z16, Z12, Z12
+	VPADDD      Z17, Z13, Z13
+	VPADDD.BCST 56(AX), Z14, Z14
+	VPADDD.BCST 60(AX), Z15, Z15
+
+	// Advance the 64 bit block counter.
+	ADDQ $16, 48(AX)
+
+	// After transposing each group of 4 words, register 4*g+j holds words
+	// 4*g to 4*g+3 of block 4*k+j in 128 bit lane k.
+	TRANSPOSE_4X4(Z0, Z1, Z2, Z3, Z20, Z21, Z22, Z23)
+	TRANSPOSE_4X4(Z4, Z5, Z6, Z7, Z20, Z21, Z22, Z23)
+	TRANSPOSE_4X4(Z8, Z9, Z10, Z11, Z20, Z21, Z22, Z23)
+	TRANSPOSE_4X4(Z12, Z13, Z14, Z15, Z20, Z21, Z22, Z23)
+
+	XOR_STORE_4(Z0, Z4, Z8, Z12, 0, Z20, Z21, Z22, Z23, Z24, Z25, Z26, Z27)
+	XOR_STORE_4(Z1, Z5, Z9, Z13, 64, Z20, Z21, Z22, Z23, Z24, Z25, Z26, Z27)
+	XOR_STORE_4(Z2, Z6, Z10, Z14, 128, Z20, Z21, Z22, Z23, Z24, Z25, Z26, Z27)
+	XOR_STORE_4(Z3, Z7, Z11, Z15, 192, Z20, Z21, Z22, Z23, Z24, Z25, Z26, Z27)
+
+	ADDQ $1024, SI
+	ADDQ $1024, DI
+	DECQ CX
+	JNZ  loop
+
+	VZEROUPPER
+
+done:
+	RET
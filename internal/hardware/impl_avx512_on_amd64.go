@@ -0,0 +1,24 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build amd64 && !noasm && chacha20_avx512
+// +build amd64,!noasm,chacha20_avx512
+
+package hardware
+
+// avx512OptIn enables the AVX-512 backend.  It is opt-in as heavy AVX-512
+// use can reduce the clock frequency of the entire core on some CPUs,
+// which may slow down unrelated code more than ChaCha20 is sped up.
+const avx512OptIn = true
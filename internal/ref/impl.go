@@ -220,23 +220,17 @@ func (impl *implRef) Blocks(x *[api.StateSize]uint32, dst, src []byte, nrBlocks
 }
 
 func (impl *implRef) HChaCha(key, nonce []byte, dst []byte) {
-	// Force bounds check elimination.
-	_ = key[31]
-	_ = nonce[api.HNonceSize-1]
+	var in [12]uint32
+	api.LoadWords(in[0:8], key[:32])
+	api.LoadWords(in[8:12], nonce[:api.HNonceSize])
 
 	x0, x1, x2, x3 := api.Sigma0, api.Sigma1, api.Sigma2, api.Sigma3
-	x4 := binary.LittleEndian.Uint32(key[0:4])
-	x5 := binary.LittleEndian.Uint32(key[4:8])
-	x6 := binary.LittleEndian.Uint32(key[8:12])
-	x7 := binary.LittleEndian.Uint32(key[12:16])
-	x8 := binary.LittleEndian.Uint32(key[16:20])
-	x9 := binary.LittleEndian.Uint32(key[20:24])
-	x10 := binary.LittleEndian.Uint32(key[24:28])
-	x11 := binary.LittleEndian.Uint32(key[28:32])
-	x12 := binary.LittleEndian.Uint32(nonce[0:4])
-	x13 := binary.LittleEndian.Uint32(nonce[4:8])
-	x14 := binary.LittleEndian.Uint32(nonce[8:12])
-	x15 := binary.LittleEndian.Uint32(nonce[12:16])
+	x4, x5, x6, x7 := in[0], in[1], in[2], in[3]
+	x8, x9, x10, x11 := in[4], in[5], in[6], in[7]
+	x12, x13, x14, x15 := in[8], in[9], in[10], in[11]
+	for i := range in {
+		in[i] = 0
+	}
 
 	// Yes, this could be carved out into a function for code reuse (TM)
 	// however the go inliner won't inline it.
@@ -0,0 +1,11 @@
+module github.com/fengxuway/chacha20/interop
+
+go 1.12
+
+require (
+	github.com/fengxuway/chacha20 v0.0.0
+	github.com/stretchr/testify v1.4.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+)
+
+replace github.com/fengxuway/chacha20 => ../
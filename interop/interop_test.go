@@ -0,0 +1,180 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package interop
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	xchacha20 "golang.org/x/crypto/chacha20"
+
+	"github.com/fengxuway/chacha20"
+	"github.com/fengxuway/chacha20/internal/api"
+	"github.com/fengxuway/chacha20/internal/hardware"
+	"github.com/fengxuway/chacha20/internal/ref"
+)
+
+const interopBlocks = 64
+
+func TestInteropXCrypto(t *testing.T) {
+	t.Run("IETF", func(t *testing.T) {
+		doTestInteropXCrypto(t, chacha20.INonceSize)
+	})
+	t.Run("XChaCha20", func(t *testing.T) {
+		doTestInteropXCrypto(t, chacha20.XNonceSize)
+	})
+}
+
+func doTestInteropXCrypto(t *testing.T, nonceSize int) {
+	require := require.New(t)
+
+	var (
+		key   [chacha20.KeySize]byte
+		nonce [chacha20.XNonceSize]byte
+		tmp   [4]byte
+
+		src, dst, expected [4096 + 3]byte
+	)
+
+	for i := 0; i < 32; i++ {
+		_, err := rand.Read(key[:])
+		require.NoError(err, "rand.Read - key")
+		_, err = rand.Read(nonce[:])
+		require.NoError(err, "rand.Read - nonce")
+		_, err = rand.Read(src[:])
+		require.NoError(err, "rand.Read - src")
+		_, err = rand.Read(tmp[:])
+		require.NoError(err, "rand.Read - counter")
+
+		// Leave room so that the IETF counter does not get exhausted.
+		ctr := binary.LittleEndian.Uint32(tmp[:]) % (math.MaxUint32 - 128)
+		if i == 0 {
+			ctr = 0
+		}
+
+		xc, err := xchacha20.NewUnauthenticatedCipher(key[:], nonce[:nonceSize])
+		require.NoError(err, "x/crypto: NewUnauthenticatedCipher")
+		xc.SetCounter(ctr)
+		xc.XORKeyStream(expected[:], src[:])
+
+		c, err := chacha20.New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		err = c.Seek(uint64(ctr))
+		require.NoError(err, "Seek")
+
+		// Use unaligned calls to exercise the buffering as well.
+		c.XORKeyStream(dst[:7], src[:7])
+		c.XORKeyStream(dst[7:2048], src[7:2048])
+		c.XORKeyStream(dst[2048:], src[2048:])
+		require.Equalf(expected, dst, "XORKeyStream: counter %d", ctr)
+	}
+}
+
+// TestInteropImpls checks every backend supported by the host, as the
+// chacha20 package only exercises the fastest one.
+func TestInteropImpls(t *testing.T) {
+	impls := hardware.Register(nil)
+	impls = ref.Register(impls)
+	for _, v := range impls {
+		impl := v
+		t.Run(impl.Name(), func(t *testing.T) {
+			t.Run("IETF", func(t *testing.T) {
+				doTestInteropImpl(t, impl, chacha20.INonceSize)
+			})
+			t.Run("XChaCha20", func(t *testing.T) {
+				doTestInteropImpl(t, impl, chacha20.XNonceSize)
+			})
+		})
+	}
+}
+
+func doTestInteropImpl(t *testing.T, impl api.Implementation, nonceSize int) {
+	require := require.New(t)
+
+	var (
+		key    [chacha20.KeySize]byte
+		nonce  [chacha20.XNonceSize]byte
+		subKey [api.HashSize]byte
+		tmp    [4]byte
+
+		dst, expected [interopBlocks * api.BlockSize]byte
+	)
+
+	for i := 0; i < 32; i++ {
+		for _, b := range [][]byte{key[:], nonce[:], tmp[:]} {
+			_, err := rand.Read(b)
+			require.NoError(err, "rand.Read")
+		}
+		ctr := binary.LittleEndian.Uint32(tmp[:]) % (math.MaxUint32 - interopBlocks)
+		if i == 0 {
+			ctr = 0
+		}
+
+		xc, err := xchacha20.NewUnauthenticatedCipher(key[:], nonce[:nonceSize])
+		require.NoError(err, "x/crypto: NewUnauthenticatedCipher")
+		xc.SetCounter(ctr)
+		for j := range expected {
+			expected[j] = 0
+		}
+		xc.XORKeyStream(expected[:], expected[:])
+
+		var x [api.StateSize]uint32
+		x[12] = ctr
+		switch nonceSize {
+		case chacha20.INonceSize:
+			api.InitState(&x, key[:])
+			api.LoadWords(x[13:16], nonce[:12])
+		case chacha20.XNonceSize:
+			xSubKey, err := xchacha20.HChaCha20(key[:], nonce[:api.HNonceSize])
+			require.NoError(err, "x/crypto: HChaCha20")
+			impl.HChaCha(key[:], nonce[:api.HNonceSize], subKey[:])
+			require.Equal(xSubKey, subKey[:], "HChaCha")
+
+			api.InitState(&x, subKey[:])
+			api.LoadWords(x[14:16], nonce[16:24])
+		}
+
+		impl.Blocks(&x, dst[:], nil, interopBlocks)
+		require.Equalf(expected, dst, "Blocks: counter %d", ctr)
+		require.Equal(ctr+interopBlocks, x[12], "Blocks: counter advanced")
+	}
+}
+
+func TestInteropXChaCha20XOR(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [chacha20.KeySize]byte
+		nonce [chacha20.XNonceSize]byte
+
+		src, dst, expected [1024 + 7]byte
+	)
+	for _, b := range [][]byte{key[:], nonce[:], src[:]} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	xc, err := xchacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	require.NoError(err, "x/crypto: NewUnauthenticatedCipher")
+	xc.XORKeyStream(expected[:], src[:])
+
+	err = chacha20.XChaCha20XOR(dst[:], src[:], nonce[:], key[:])
+	require.NoError(err, "XChaCha20XOR")
+	require.Equal(expected, dst, "XChaCha20XOR")
+}
@@ -0,0 +1,295 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+// copyBufferSize is the size of the buffer used by EncryptCopy.
+const copyBufferSize = 32 * 1024
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([copyBufferSize]byte)
+	},
+}
+
+var (
+	// ErrShortNoncePrefix is the error returned when a stream ends before
+	// the nonce prefix could be read in full.
+	ErrShortNoncePrefix = errors.New("chacha20: short nonce prefix")
+
+	_ io.ReadSeeker = (*readSeeker)(nil)
+)
+
+// EncryptCopy reads from src until EOF or an error occurs, and writes the
+// result of XORing what was read with the key stream to dst.  It returns the
+// number of bytes written, and the first error encountered, if any.
+func (c *Cipher) EncryptCopy(dst io.Writer, src io.Reader) (int64, error) {
+	return c.encryptCopy(dst, src, nil, false)
+}
+
+// XORStream is EncryptCopy, that additionally writes the output to tap (eg:
+// to compute a MAC over the ciphertext) if it is not nil.  The returned
+// count is the number of bytes written to dst.
+func (c *Cipher) XORStream(dst io.Writer, src io.Reader, tap io.Writer) (int64, error) {
+	return c.encryptCopy(dst, src, tap, false)
+}
+
+// XStreamEncrypt reads from r until EOF or an error occurs, and writes the
+// result of XORing what was read with the XChaCha20 key stream generated with
+// the provided key and nonce to w.  It returns the number of bytes written,
+// and the first error encountered, if any.  Rather than allowing the 64 bit
+// block counter to wrap, ErrKeyStreamExhausted is returned.
+func XStreamEncrypt(w io.Writer, r io.Reader, key, nonce []byte) (int64, error) {
+	c, err := NewX(key, nonce)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Reset()
+
+	return c.encryptCopy(w, r, nil, true)
+}
+
+func (c *Cipher) encryptCopy(dst io.Writer, src io.Reader, tap io.Writer, noWrap bool) (int64, error) {
+	bufArr := copyBufferPool.Get().(*[copyBufferSize]byte)
+	defer func() {
+		for i := range bufArr {
+			bufArr[i] = 0
+		}
+		copyBufferPool.Put(bufArr)
+	}()
+	buf := bufArr[:]
+
+	var (
+		written int64
+		wrapped bool
+	)
+	for {
+		nr, rdErr := src.Read(buf)
+		if nr > 0 {
+			var xorErr error
+			if noWrap {
+				if n := c.remainingNoWrap(nr, wrapped); n < nr {
+					nr, xorErr = n, ErrKeyStreamExhausted
+				}
+			}
+			c.XORKeyStream(buf[:nr], buf[:nr])
+			wrapped = wrapped || (nr > 0 && c.atWrap())
+			nw, wrErr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if wrErr != nil {
+				return written, wrErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+			if tap != nil {
+				nw, wrErr = tap.Write(buf[:nr])
+				if wrErr != nil {
+					return written, wrErr
+				}
+				if nw != nr {
+					return written, io.ErrShortWrite
+				}
+			}
+			if xorErr != nil {
+				return written, xorErr
+			}
+		}
+		if rdErr == io.EOF {
+			return written, nil
+		}
+		if rdErr != nil {
+			return written, rdErr
+		}
+	}
+}
+
+// remainingNoWrap returns how many of the next n bytes of key stream can be
+// generated without the 64 bit block counter wrapping around to 0, which is
+// what Remaining reports.  wrapped must be set if atWrap returned true after
+// generating key stream, as the block counter is then 0 again.
+func (c *Cipher) remainingNoWrap(n int, wrapped bool) int {
+	if wrapped {
+		return 0
+	}
+	if remaining := c.Remaining(); uint64(n) > remaining {
+		return int(remaining)
+	}
+	return n
+}
+
+// atWrap returns true iff the final block before the 64 bit block counter
+// wraps has been fully consumed.  It is only meaningful after key stream has
+// been generated, as a fresh instance is in the same state.
+func (c *Cipher) atWrap() bool {
+	return c.mode != ModeIETF && c.counter() == 0 && c.off == api.BlockSize
+}
+
+type readSeeker struct {
+	c Cipher
+	r io.ReaderAt
+
+	size int64
+	pos  int64
+}
+
+func (rs *readSeeker) Read(p []byte) (int, error) {
+	if rs.pos >= rs.size {
+		return 0, io.EOF
+	}
+	if remaining := rs.size - rs.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := rs.r.ReadAt(p, rs.pos)
+	rs.c.XORKeyStream(p[:n], p[:n])
+	rs.pos += int64(n)
+	if err == io.EOF && rs.pos == rs.size {
+		// The io.ReaderAt may return io.EOF along with the last bytes.
+		err = nil
+	}
+
+	return n, err
+}
+
+func (rs *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = rs.pos + offset
+	case io.SeekEnd:
+		pos = rs.size + offset
+	default:
+		return 0, errors.New("chacha20: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("chacha20: negative position")
+	}
+
+	if err := rs.c.seekBytes(uint64(pos)); err != nil {
+		return 0, err
+	}
+	rs.pos = pos
+
+	return pos, nil
+}
+
+// NewReadSeeker returns an io.ReadSeeker that decrypts the size bytes of
+// ciphertext provided by r, that was encrypted with the provided key and
+// nonce starting at block 0.  Seeking repositions the key stream directly,
+// so reading from an arbitrary offset does not require decrypting all of
+// the data that precedes it.
+func NewReadSeeker(r io.ReaderAt, key, nonce []byte, size int64) (io.ReadSeeker, error) {
+	if size < 0 {
+		return nil, errors.New("chacha20: negative size")
+	}
+
+	rs := &readSeeker{
+		r:    r,
+		size: size,
+	}
+	if err := rs.c.doReKey(key, nonce); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+type xReader struct {
+	c   Cipher
+	r   io.Reader
+	key [KeySize]byte
+
+	keyed   bool
+	wrapped bool
+	err     error
+}
+
+func (xr *xReader) Read(p []byte) (int, error) {
+	if xr.err != nil {
+		return 0, xr.err
+	}
+	if !xr.keyed {
+		if err := xr.init(); err != nil {
+			xr.err = err
+			return 0, err
+		}
+	}
+
+	n, err := xr.r.Read(p)
+	if n > 0 {
+		if nOk := xr.c.remainingNoWrap(n, xr.wrapped); nOk < n {
+			n, err = nOk, ErrKeyStreamExhausted
+			xr.err = err
+		}
+		xr.c.XORKeyStream(p[:n], p[:n])
+		xr.wrapped = xr.wrapped || (n > 0 && xr.c.atWrap())
+	}
+	if err == io.EOF {
+		xr.c.Reset()
+		xr.err = err
+	}
+
+	return n, err
+}
+
+func (xr *xReader) init() error {
+	defer func() {
+		for i := range xr.key {
+			xr.key[i] = 0
+		}
+	}()
+
+	var nonce [XNonceSize]byte
+	if _, err := io.ReadFull(xr.r, nonce[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrShortNoncePrefix
+		}
+		return err
+	}
+	if err := xr.c.doReKey(xr.key[:], nonce[:]); err != nil {
+		return err
+	}
+	xr.keyed = true
+
+	return nil
+}
+
+// NewXReader returns an io.Reader that decrypts a nonce prefixed XChaCha20
+// stream read from r, with the provided key.  The XNonceSize byte nonce is
+// read, and the subkey derived, on the first call to Read.  If r ends before
+// the full nonce is read, Read returns ErrShortNoncePrefix.  Rather than
+// allowing the 64 bit block counter to wrap, ErrKeyStreamExhausted is
+// returned.
+func NewXReader(r io.Reader, key []byte) (io.Reader, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	xr := &xReader{r: r}
+	copy(xr.key[:], key)
+
+	return xr, nil
+}
@@ -0,0 +1,464 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestReadSeeker(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+		tmp   [8]byte
+	)
+
+	plaintext := make([]byte, 1<<20+13)
+	for _, b := range [][]byte{key[:], nonce[:], plaintext} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	ciphertext := make([]byte, len(plaintext))
+	c.XORKeyStream(ciphertext, plaintext)
+
+	size := int64(len(ciphertext))
+	rs, err := NewReadSeeker(bytes.NewReader(ciphertext), key[:], nonce[:], size)
+	require.NoError(err, "NewReadSeeker")
+
+	b, err := ioutil.ReadAll(rs)
+	require.NoError(err, "ReadAll")
+	require.Equal(plaintext, b, "ReadAll - output")
+
+	for i := 0; i < 256; i++ {
+		_, err = rand.Read(tmp[:])
+		require.NoError(err, "rand.Read")
+		off := int64(binary.LittleEndian.Uint32(tmp[0:4]) % uint32(size))
+		n := int(binary.LittleEndian.Uint32(tmp[4:8]) % 1024)
+
+		pos, err := rs.Seek(off, io.SeekStart)
+		require.NoError(err, "Seek")
+		require.Equal(off, pos, "Seek - position")
+
+		b = make([]byte, n)
+		n, err = io.ReadFull(rs, b)
+		if off+int64(len(b)) > size {
+			require.Equal(io.ErrUnexpectedEOF, err, "ReadFull - past end")
+		} else {
+			require.NoError(err, "ReadFull")
+		}
+		require.Equalf(plaintext[off:off+int64(n)], b[:n], "ReadFull: offset %d", off)
+	}
+
+	// Relative seeks.
+	pos, err := rs.Seek(-65, io.SeekEnd)
+	require.NoError(err, "Seek - SeekEnd")
+	require.Equal(size-65, pos, "Seek - SeekEnd position")
+	pos, err = rs.Seek(-1, io.SeekCurrent)
+	require.NoError(err, "Seek - SeekCurrent")
+	b, err = ioutil.ReadAll(rs)
+	require.NoError(err, "ReadAll - tail")
+	require.Equal(plaintext[pos:], b, "ReadAll - tail output")
+
+	_, err = rs.Seek(-1, io.SeekStart)
+	require.Error(err, "Seek - negative")
+
+	_, err = NewReadSeeker(bytes.NewReader(ciphertext), key[:], nonce[:5], size)
+	require.Equal(ErrInvalidNonce, err, "NewReadSeeker - invalid nonce")
+}
+
+type shortWriter struct {
+	n int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func TestEncryptCopy(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+
+	plaintext := make([]byte, 5*copyBufferSize+17)
+	for _, b := range [][]byte{key[:], nonce[:], plaintext} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	// Encrypt through a pipe.
+	pr, pw := io.Pipe()
+	go func() {
+		for b := plaintext; len(b) > 0; {
+			n := 1000
+			if n > len(b) {
+				n = len(b)
+			}
+			_, _ = pw.Write(b[:n])
+			b = b[n:]
+		}
+		pw.Close()
+	}()
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	var ciphertext bytes.Buffer
+	n, err := c.EncryptCopy(&ciphertext, pr)
+	require.NoError(err, "EncryptCopy")
+	require.EqualValues(len(plaintext), n, "EncryptCopy - length")
+
+	expected := make([]byte, len(plaintext))
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.XORKeyStream(expected, plaintext)
+	require.Equal(expected, ciphertext.Bytes(), "EncryptCopy - ciphertext")
+
+	// Decrypt.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New - decrypt")
+	var decrypted bytes.Buffer
+	n, err = c.EncryptCopy(&decrypted, &ciphertext)
+	require.NoError(err, "EncryptCopy - decrypt")
+	require.EqualValues(len(plaintext), n, "EncryptCopy - decrypt length")
+	require.Equal(plaintext, decrypted.Bytes(), "EncryptCopy - round trip")
+
+	// Errors.
+	n, err = c.EncryptCopy(&shortWriter{n: 10}, bytes.NewReader(plaintext))
+	require.Equal(io.ErrShortWrite, err, "EncryptCopy - short write")
+	require.EqualValues(10, n, "EncryptCopy - short write length")
+
+	rdErr := errors.New("read failed")
+	n, err = c.EncryptCopy(ioutil.Discard, &errReader{bytes.NewReader(plaintext[:100]), rdErr})
+	require.Equal(rdErr, err, "EncryptCopy - read error")
+	require.EqualValues(100, n, "EncryptCopy - read error length")
+
+	// The copy buffer is pooled.
+	r := bytes.NewReader(plaintext)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(plaintext)
+		_, _ = c.EncryptCopy(ioutil.Discard, r)
+	})
+	require.Zero(allocs, "EncryptCopy - allocs")
+}
+
+func TestXORStream(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+	)
+
+	plaintext := make([]byte, 2*copyBufferSize+100)
+	for _, b := range [][]byte{key[:], nonce[:], plaintext} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	expected := make([]byte, len(plaintext))
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	c.XORKeyStream(expected, plaintext)
+
+	// Without a tap.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	var ciphertext bytes.Buffer
+	n, err := c.XORStream(&ciphertext, bytes.NewReader(plaintext), nil)
+	require.NoError(err, "XORStream")
+	require.EqualValues(len(plaintext), n, "XORStream - length")
+	require.Equal(expected, ciphertext.Bytes(), "XORStream - ciphertext")
+
+	// With a tap.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	var tapped bytes.Buffer
+	ciphertext.Reset()
+	n, err = c.XORStream(&ciphertext, bytes.NewReader(plaintext), &tapped)
+	require.NoError(err, "XORStream - tap")
+	require.EqualValues(len(plaintext), n, "XORStream - tap length")
+	require.Equal(expected, ciphertext.Bytes(), "XORStream - tap ciphertext")
+	require.Equal(expected, tapped.Bytes(), "XORStream - tapped ciphertext")
+
+	// Round trip.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New - decrypt")
+	var decrypted bytes.Buffer
+	n, err = c.XORStream(&decrypted, &ciphertext, ioutil.Discard)
+	require.NoError(err, "XORStream - decrypt")
+	require.EqualValues(len(plaintext), n, "XORStream - decrypt length")
+	require.Equal(plaintext, decrypted.Bytes(), "XORStream - round trip")
+
+	// Errors.
+	n, err = c.XORStream(&shortWriter{n: 10}, bytes.NewReader(plaintext), nil)
+	require.Equal(io.ErrShortWrite, err, "XORStream - short write")
+	require.EqualValues(10, n, "XORStream - short write length")
+
+	n, err = c.XORStream(ioutil.Discard, bytes.NewReader(plaintext), &shortWriter{n: 10})
+	require.Equal(io.ErrShortWrite, err, "XORStream - short tap write")
+	require.EqualValues(copyBufferSize, n, "XORStream - short tap write length")
+
+	rdErr := errors.New("read failed")
+	n, err = c.XORStream(ioutil.Discard, &errReader{bytes.NewReader(plaintext[:100]), rdErr}, &tapped)
+	require.Equal(rdErr, err, "XORStream - read error")
+	require.EqualValues(100, n, "XORStream - read error length")
+}
+
+func TestXStreamEncrypt(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+
+	plaintext := make([]byte, 3*copyBufferSize+17)
+	for _, b := range [][]byte{key[:], nonce[:], plaintext} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	var ciphertext bytes.Buffer
+	n, err := XStreamEncrypt(&ciphertext, bytes.NewReader(plaintext), key[:], nonce[:])
+	require.NoError(err, "XStreamEncrypt")
+	require.EqualValues(len(plaintext), n, "XStreamEncrypt - length")
+
+	expected := make([]byte, len(plaintext))
+	c, err := NewX(key[:], nonce[:])
+	require.NoError(err, "NewX")
+	c.XORKeyStream(expected, plaintext)
+	require.Equal(expected, ciphertext.Bytes(), "XStreamEncrypt - ciphertext")
+
+	_, err = XStreamEncrypt(ioutil.Discard, bytes.NewReader(plaintext), key[:], nonce[:INonceSize])
+	require.Equal(ErrInvalidNonce, err, "XStreamEncrypt - IETF nonce")
+
+	// The 64 bit block counter is not allowed to wrap, and the key stream
+	// is usable up to exactly what Remaining reports.
+	zeros := make([]byte, 10*api.BlockSize+5)
+	for _, skip := range []int{0, 10} {
+		err = c.Seek(math.MaxUint64 - 2)
+		require.NoError(err, "Seek")
+		c.KeyStream(zeros[:skip])
+		remaining := c.Remaining()
+		require.EqualValues(3*api.BlockSize-skip, remaining, "Remaining")
+
+		n, err = c.encryptCopy(ioutil.Discard, bytes.NewReader(zeros), nil, true)
+		require.Equalf(ErrKeyStreamExhausted, err, "encryptCopy - wrap, skip %d", skip)
+		require.EqualValuesf(remaining, n, "encryptCopy - wrap length, skip %d", skip)
+	}
+
+	// Ending exactly at the limit is fine, but nothing more may follow.
+	err = c.Seek(math.MaxUint64 - 1)
+	require.NoError(err, "Seek")
+	n, err = c.encryptCopy(ioutil.Discard, bytes.NewReader(zeros[:2*api.BlockSize]), nil, true)
+	require.NoError(err, "encryptCopy - exactly at the limit")
+	require.EqualValues(2*api.BlockSize, n, "encryptCopy - exactly at the limit length")
+
+	err = c.Seek(math.MaxUint64 - 1)
+	require.NoError(err, "Seek")
+	r := io.MultiReader(bytes.NewReader(zeros[:2*api.BlockSize]), bytes.NewReader(zeros[:1]))
+	n, err = c.encryptCopy(ioutil.Discard, r, nil, true)
+	require.Equal(ErrKeyStreamExhausted, err, "encryptCopy - past the limit")
+	require.EqualValues(2*api.BlockSize, n, "encryptCopy - past the limit length")
+}
+
+// zeroReader returns size zero bytes, and then io.EOF.
+type zeroReader struct {
+	size int64
+}
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if r.size == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size {
+		p = p[:r.size]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.size -= int64(len(p))
+	return len(p), nil
+}
+
+// windowWriter discards everything written to it, except for the bytes
+// at offsets [off, off + len(window)).
+type windowWriter struct {
+	window []byte
+	off    int64
+	pos    int64
+}
+
+func (w *windowWriter) Write(p []byte) (int, error) {
+	start, end := w.pos, w.pos+int64(len(p))
+	wStart, wEnd := w.off, w.off+int64(len(w.window))
+	if start < wEnd && end > wStart {
+		lo, hi := start, end
+		if lo < wStart {
+			lo = wStart
+		}
+		if hi > wEnd {
+			hi = wEnd
+		}
+		copy(w.window[lo-wStart:hi-wStart], p[lo-start:hi-start])
+	}
+	w.pos = end
+	return len(p), nil
+}
+
+func TestXStreamEncryptPast256GiB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping encrypting more than 256 GiB in short mode")
+	}
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+	for _, b := range [][]byte{key[:], nonce[:]} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	// Stream across the 256 GiB (32 bit block counter) boundary, capturing
+	// the output around it.
+	const boundary = (math.MaxUint32 + 1) * api.BlockSize
+	size := int64(boundary + 3*api.BlockSize + 5)
+	w := &windowWriter{
+		window: make([]byte, 6*api.BlockSize),
+		off:    boundary - 3*api.BlockSize,
+	}
+	n, err := XStreamEncrypt(w, &zeroReader{size: size}, key[:], nonce[:])
+	require.NoError(err, "XStreamEncrypt - past 256 GiB")
+	require.Equal(size, n, "XStreamEncrypt - past 256 GiB length")
+
+	expected := make([]byte, len(w.window))
+	c, err := NewX(key[:], nonce[:])
+	require.NoError(err, "NewX")
+	err = c.Seek(math.MaxUint32 - 2)
+	require.NoError(err, "Seek")
+	c.KeyStream(expected)
+	require.Equal(expected, w.window, "XStreamEncrypt - past 256 GiB")
+}
+
+func TestXReader(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+
+	plaintext := make([]byte, 3*copyBufferSize+17)
+	for _, b := range [][]byte{key[:], nonce[:], plaintext} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	// The writer side emits the nonce prefix, followed by the ciphertext.
+	var stream bytes.Buffer
+	_, _ = stream.Write(nonce[:])
+	_, err := XStreamEncrypt(&stream, bytes.NewReader(plaintext), key[:], nonce[:])
+	require.NoError(err, "XStreamEncrypt")
+
+	xr, err := NewXReader(bytes.NewReader(stream.Bytes()), key[:])
+	require.NoError(err, "NewXReader")
+	b, err := ioutil.ReadAll(xr)
+	require.NoError(err, "ReadAll")
+	require.Equal(plaintext, b, "ReadAll - round trip")
+
+	n, err := xr.Read(b)
+	require.Equal(io.EOF, err, "Read - after EOF")
+	require.Zero(n, "Read - after EOF")
+
+	// Empty ciphertext.
+	xr, err = NewXReader(bytes.NewReader(nonce[:]), key[:])
+	require.NoError(err, "NewXReader")
+	b, err = ioutil.ReadAll(xr)
+	require.NoError(err, "ReadAll - empty")
+	require.Empty(b, "ReadAll - empty")
+
+	for _, sz := range []int{0, 1, XNonceSize - 1} {
+		xr, err = NewXReader(bytes.NewReader(nonce[:sz]), key[:])
+		require.NoError(err, "NewXReader")
+		_, err = ioutil.ReadAll(xr)
+		require.Equalf(ErrShortNoncePrefix, err, "ReadAll - prefix size: %d", sz)
+	}
+
+	// Errors from r are passed through, both while reading the nonce, and
+	// while reading the ciphertext.
+	rdErr := errors.New("read failed")
+	for _, sz := range []int{XNonceSize - 1, XNonceSize + 100} {
+		xr, err = NewXReader(&errReader{bytes.NewReader(stream.Bytes()[:sz]), rdErr}, key[:])
+		require.NoError(err, "NewXReader")
+		b, err = ioutil.ReadAll(xr)
+		require.Equalf(rdErr, err, "ReadAll - read error, size: %d", sz)
+		if sz > XNonceSize {
+			require.Equal(plaintext[:sz-XNonceSize], b, "ReadAll - read error, output")
+		}
+	}
+
+	// The 64 bit block counter is not allowed to wrap.
+	xr, err = NewXReader(bytes.NewReader(stream.Bytes()), key[:])
+	require.NoError(err, "NewXReader")
+	_, err = xr.Read(b[:0])
+	require.NoError(err, "Read - nonce")
+	err = xr.(*xReader).c.Seek(math.MaxUint64 - 1)
+	require.NoError(err, "Seek")
+	b = make([]byte, 3*api.BlockSize)
+	n, err = io.ReadFull(xr, b)
+	require.Equal(ErrKeyStreamExhausted, err, "ReadFull - wrap")
+	require.Equal(2*api.BlockSize, n, "ReadFull - wrap length")
+
+	_, err = NewXReader(bytes.NewReader(stream.Bytes()), key[:KeySize-1])
+	require.Equal(ErrInvalidKey, err, "NewXReader - invalid key")
+}
@@ -0,0 +1,110 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// NonceSequence generates unique nonces for use with a single key, by
+// XORing a 64 bit message counter (big endian) into the last 8 bytes of a
+// base nonce, similar to the TLS 1.3 per-record nonce construction.
+//
+// A sequence yields at most 2^64 nonces, after which Next panics rather than
+// repeating a nonce.  Every nonce from a sequence is unique only as long as
+// the sequence itself is not duplicated or restarted with the same key and
+// base.  Each message may be at most 256 GiB in IETF mode (the 32 bit block
+// counter).
+//
+// Randomly generated nonces are only safe for XChaCha20, where the 192 bit
+// nonce makes collisions negligible.  For the 96 bit IETF nonce, random
+// generation should be limited to 2^32 messages per key, and the 64 bit
+// nonce should never be generated randomly.
+type NonceSequence struct {
+	base      [XNonceSize]byte
+	size      int
+	ctr       uint64
+	exhausted bool
+}
+
+// NewNonceSequence returns a new NonceSequence starting from the provided
+// base nonce, which must be NonceSize, INonceSize, or XNonceSize bytes.
+func NewNonceSequence(base []byte) (*NonceSequence, error) {
+	switch len(base) {
+	case NonceSize, INonceSize, XNonceSize:
+	default:
+		return nil, ErrInvalidNonce
+	}
+
+	s := &NonceSequence{
+		size: len(base),
+	}
+	copy(s.base[:], base)
+
+	return s, nil
+}
+
+// Next returns the next nonce in the sequence.  It will panic if the
+// sequence is exhausted.
+func (s *NonceSequence) Next() []byte {
+	if s.exhausted {
+		panic("chacha20: nonce sequence exhausted")
+	}
+
+	nonce := make([]byte, s.size)
+	copy(nonce, s.base[:s.size])
+
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], s.ctr)
+	tail := nonce[s.size-8:]
+	for i := range ctr {
+		tail[i] ^= ctr[i]
+	}
+
+	s.ctr++
+	s.exhausted = s.ctr == 0
+
+	return nonce
+}
+
+// RandomNonce returns a new nonce for the provided ChaCha20 variant, read
+// from crypto/rand.
+//
+// Random nonces are only safe to use without limit for XChaCha20.  Due to
+// the birthday bound, random IETF 96 bit nonces should be limited to 2^32
+// messages per key, and random 64 bit nonces should be avoided entirely
+// (see NonceSequence).
+func RandomNonce(mode Mode) ([]byte, error) {
+	nonceSize := mode.nonceSize()
+	if nonceSize == 0 {
+		return nil, ErrInvalidNonce
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+// RandomNonce returns a new nonce for the ChaCha20 variant used by the
+// instance, read from crypto/rand.  See the package level RandomNonce for
+// when random nonces are safe.
+func (c *Cipher) RandomNonce() ([]byte, error) {
+	return RandomNonce(c.mode)
+}
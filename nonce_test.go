@@ -0,0 +1,98 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonceSequence(t *testing.T) {
+	require := require.New(t)
+
+	var base [XNonceSize]byte
+	for i := range base {
+		base[i] = byte(i + 1)
+	}
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		s, err := NewNonceSequence(base[:nonceSize])
+		require.NoError(err, "NewNonceSequence")
+
+		seen := make(map[string]bool)
+		for i := 0; i < 1024; i++ {
+			nonce := s.Next()
+			require.Len(nonce, nonceSize, "Next - length")
+			require.False(seen[string(nonce)], "Next - duplicate nonce")
+			seen[string(nonce)] = true
+		}
+
+		// The first nonce is the base, and the counter is XORed in big endian.
+		s, err = NewNonceSequence(base[:nonceSize])
+		require.NoError(err, "NewNonceSequence")
+		require.Equal(base[:nonceSize], s.Next(), "Next - first")
+		expected := append([]byte{}, base[:nonceSize]...)
+		expected[nonceSize-1] ^= 1
+		require.Equal(expected, s.Next(), "Next - second")
+		require.Equal(base[:nonceSize-8], expected[:nonceSize-8], "Next - prefix")
+
+		// Wrap detection.
+		s.ctr = math.MaxUint64
+		_ = s.Next()
+		require.Panics(func() {
+			s.Next()
+		}, "Next - exhausted")
+	}
+
+	for _, sz := range []int{0, 7, 11, 13, 16, 23, 25} {
+		_, err := NewNonceSequence(make([]byte, sz))
+		require.Equalf(ErrInvalidNonce, err, "NewNonceSequence - nonce size: %d", sz)
+	}
+}
+
+func TestRandomNonce(t *testing.T) {
+	require := require.New(t)
+
+	var key [KeySize]byte
+
+	for _, v := range []struct {
+		mode      Mode
+		nonceSize int
+	}{
+		{ModeDJB, NonceSize},
+		{ModeIETF, INonceSize},
+		{ModeXChaCha20, XNonceSize},
+	} {
+		nonce, err := RandomNonce(v.mode)
+		require.NoErrorf(err, "RandomNonce: %v", v.mode)
+		require.Lenf(nonce, v.nonceSize, "RandomNonce - length: %v", v.mode)
+
+		nonce2, err := RandomNonce(v.mode)
+		require.NoErrorf(err, "RandomNonce: %v", v.mode)
+		require.NotEqualf(nonce, nonce2, "RandomNonce - distinct: %v", v.mode)
+
+		c, err := New(key[:], nonce)
+		require.NoError(err, "New")
+		nonce, err = c.RandomNonce()
+		require.NoErrorf(err, "Cipher.RandomNonce: %v", v.mode)
+		require.Lenf(nonce, v.nonceSize, "Cipher.RandomNonce - length: %v", v.mode)
+	}
+
+	_, err := RandomNonce(Mode(42))
+	require.Equal(ErrInvalidNonce, err, "RandomNonce - invalid mode")
+}
@@ -0,0 +1,95 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+// parallelMinBlocks is the minimum number of blocks each ParallelXORKeyStream
+// worker is assigned, below which the goroutine overhead dominates.
+const parallelMinBlocks = 64
+
+// ParallelXORKeyStream sets dst to the result of XORing src with the key
+// stream generated with the provided key and nonce, starting from block 0,
+// with the variant selected based on the length of the nonce.  The work is
+// split into block aligned ranges across up to workers goroutines, each
+// with its own copy of the cipher.  If workers is less than 1,
+// runtime.GOMAXPROCS(0) is used.
+//
+// The output is identical to that of XORKeyStream.  In IETF mode,
+// ErrKeyStreamExhausted is returned without altering dst if src is larger
+// than the key stream per nonce limit.
+func ParallelXORKeyStream(dst, src, key, nonce []byte, workers int) error {
+	var c Cipher
+	if err := c.doReKey(key, nonce); err != nil {
+		return err
+	}
+	defer c.Reset()
+
+	// Check everything that XORKeyStream would panic on up front, as a
+	// panic in a worker can not be recovered by the caller.
+	if len(dst) < len(src) {
+		panic("chacha20: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("chacha20: invalid buffer overlap")
+	}
+	if c.mode == ModeIETF && uint64(len(src)) > c.ietfRemaining() {
+		return ErrKeyStreamExhausted
+	}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	nrBlocks := (len(src) + api.BlockSize - 1) / api.BlockSize
+	if maxWorkers := nrBlocks / parallelMinBlocks; workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		c.XORKeyStream(dst, src)
+		return nil
+	}
+
+	chunkBlocks := (nrBlocks + workers - 1) / workers
+	chunkSize := chunkBlocks * api.BlockSize
+
+	var wg sync.WaitGroup
+	for off, blk := 0, uint64(0); off < len(src); off, blk = off+chunkSize, blk+uint64(chunkBlocks) {
+		end := off + chunkSize
+		if end > len(src) {
+			end = len(src)
+		}
+
+		wg.Add(1)
+		go func(wc Cipher, d, s []byte, blk uint64) {
+			defer wg.Done()
+			defer wc.Reset()
+
+			// The range was checked against the limit above.
+			if err := wc.Seek(blk); err != nil {
+				panic("chacha20: BUG: failed to seek worker: " + err.Error())
+			}
+			wc.XORKeyStream(d, s)
+		}(c, dst[off:end], src[off:end], blk)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/rand"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestParallelXORKeyStream(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+
+	src := make([]byte, 1<<20+13)
+	for _, b := range [][]byte{key[:], nonce[:], src} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		for _, n := range []int{0, 1, api.BlockSize*parallelMinBlocks + 1, 100003, len(src)} {
+			expected := make([]byte, n)
+			err := XORKeyStream(expected, src[:n], key[:], nonce[:nonceSize])
+			require.NoError(err, "XORKeyStream")
+
+			for _, workers := range []int{0, 1, 2, 3, 7, 64} {
+				dst := make([]byte, n)
+				err = ParallelXORKeyStream(dst, src[:n], key[:], nonce[:nonceSize], workers)
+				require.NoError(err, "ParallelXORKeyStream")
+				require.Equalf(expected, dst, "nonce: %d, len: %d, workers: %d", nonceSize, n, workers)
+			}
+
+			// In-place.
+			dst := make([]byte, n)
+			copy(dst, src)
+			err = ParallelXORKeyStream(dst, dst, key[:], nonce[:nonceSize], 4)
+			require.NoError(err, "ParallelXORKeyStream - in-place")
+			require.Equal(expected, dst, "ParallelXORKeyStream - in-place output")
+		}
+	}
+
+	err := ParallelXORKeyStream(src, src, key[:1], nonce[:], 2)
+	require.Equal(ErrInvalidKey, err, "ParallelXORKeyStream - invalid key")
+	err = ParallelXORKeyStream(src, src, key[:], nonce[:1], 2)
+	require.Equal(ErrInvalidNonce, err, "ParallelXORKeyStream - invalid nonce")
+	require.Panics(func() {
+		_ = ParallelXORKeyStream(src[:1], src, key[:], nonce[:], 2)
+	}, "ParallelXORKeyStream - short dst")
+	require.Panics(func() {
+		_ = ParallelXORKeyStream(src[1:], src, key[:], nonce[:], 2)
+	}, "ParallelXORKeyStream - overlap")
+}
+
+func BenchmarkParallelXORKeyStream(b *testing.B) {
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+	)
+	buf := make([]byte, 16<<20)
+
+	for _, workers := range []int{1, 2, 4, 0} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			b.SetBytes(int64(len(buf)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ParallelXORKeyStream(buf, buf, key[:], nonce[:], workers); err != nil {
+					b.Fatalf("ParallelXORKeyStream: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import "sync"
+
+var cipherPool = sync.Pool{
+	New: func() interface{} {
+		return new(Cipher)
+	},
+}
+
+// AcquireCipher returns a ChaCha20/XChaCha20 instance from a shared pool,
+// initialized with the provided key and nonce.  The instance behaves exactly
+// as one returned from New, and should be returned to the pool with
+// ReleaseCipher when it is no longer needed.
+func AcquireCipher(key, nonce []byte) (*Cipher, error) {
+	c := cipherPool.Get().(*Cipher)
+	if err := c.doReKey(key, nonce); err != nil {
+		cipherPool.Put(c)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ReleaseCipher zeros the key data of an instance obtained via AcquireCipher,
+// and returns it to the shared pool.  The instance must not be used after
+// it has been released.
+func ReleaseCipher(c *Cipher) {
+	c.Reset()
+	cipherPool.Put(c)
+}
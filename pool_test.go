@@ -0,0 +1,75 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestPool(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+
+		expected, out [3 * api.BlockSize]byte
+	)
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(expected[:])
+
+		// Dirty an instance, and return it to the pool.
+		c, err = AcquireCipher(key[:], nonce[:nonceSize])
+		require.NoError(err, "AcquireCipher")
+		c.KeyStream(out[:7])
+		ReleaseCipher(c)
+		require.Equal([api.StateSize]uint32{}, c.state, "ReleaseCipher - state")
+		require.Equal([api.BlockSize]byte{}, c.buf, "ReleaseCipher - buf")
+
+		c, err = AcquireCipher(key[:], nonce[:nonceSize])
+		require.NoError(err, "AcquireCipher - again")
+		c.KeyStream(out[:])
+		require.Equal(expected, out, "KeyStream - pooled instance")
+		ReleaseCipher(c)
+	}
+
+	_, err := AcquireCipher(key[:1], nonce[:NonceSize])
+	require.Equal(ErrInvalidKey, err, "AcquireCipher - invalid key")
+}
+
+func BenchmarkPool(b *testing.B) {
+	var (
+		key   [KeySize]byte
+		nonce [INonceSize]byte
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := AcquireCipher(key[:], nonce[:])
+		if err != nil {
+			b.Fatal(err)
+		}
+		ReleaseCipher(c)
+	}
+}
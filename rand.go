@@ -0,0 +1,174 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+)
+
+var (
+	// ErrInvalidReseedInterval is the error returned when the CSPRNG reseed
+	// interval is invalid.
+	ErrInvalidReseedInterval = errors.New("chacha20: reseed interval must be positive")
+
+	_ rand.Source64 = (*randSource)(nil)
+	_ io.Reader     = (*csprng)(nil)
+)
+
+type randSource struct {
+	c Cipher
+}
+
+func (s *randSource) Int63() int64 {
+	return int64(s.Uint64() & (1<<63 - 1))
+}
+
+func (s *randSource) Uint64() uint64 {
+	var tmp [8]byte
+	s.c.KeyStream(tmp[:])
+	return binary.LittleEndian.Uint64(tmp[:])
+}
+
+// Seed re-initializes the source with a key consisting of the little endian
+// encoding of seed followed by zero bytes.  It only exists to satisfy the
+// rand.Source interface, and callers should use NewRandSource instead.
+func (s *randSource) Seed(seed int64) {
+	var key [KeySize]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(seed))
+	s.reseed(&key)
+}
+
+func (s *randSource) reseed(seed *[KeySize]byte) {
+	var nonce [NonceSize]byte
+	if err := s.c.ReKey(seed[:], nonce[:]); err != nil {
+		panic("chacha20: failed to seed rand.Source: " + err.Error())
+	}
+}
+
+// NewRandSource returns a math/rand.Source64 that returns successive 64 bit
+// little endian words of the ChaCha20 keystream, keyed with seed and an all
+// zero 64 bit nonce.
+//
+// The output is entirely deterministic given the seed.  As the 64 bit block
+// counter is used, the keystream will not repeat until 2^70 bytes of output
+// have been generated.
+func NewRandSource(seed [KeySize]byte) rand.Source64 {
+	var s randSource
+	s.reseed(&seed)
+	return &s
+}
+
+type csprng struct {
+	c Cipher
+
+	interval  int
+	remaining int
+}
+
+func (r *csprng) Read(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		toRead := r.remaining
+		if len(p) < toRead {
+			toRead = len(p)
+		}
+		r.c.KeyStream(p[:toRead])
+		p = p[toRead:]
+
+		r.remaining -= toRead
+		if r.remaining == 0 {
+			r.rekey()
+		}
+	}
+
+	return n, nil
+}
+
+func (r *csprng) rekey() {
+	var key [KeySize]byte
+	r.c.KeyStream(key[:])
+	_ = r.setKey(key[:]) // Can not fail, the key is always KeySize bytes.
+	for i := range key {
+		key[i] = 0
+	}
+}
+
+func (r *csprng) setKey(key []byte) error {
+	var nonce [NonceSize]byte
+	if err := r.c.ReKey(key, nonce[:]); err != nil {
+		return err
+	}
+	r.remaining = r.interval
+	return nil
+}
+
+// NewCSPRNG returns an io.Reader that returns the ChaCha20 keystream generated
+// with the provided key and an all zero 64 bit nonce.  After every
+// reseedInterval bytes of output, the next KeySize bytes of keystream are
+// used to rekey the instance, and are never returned to the caller
+// ("fast-key-erasure").
+//
+// Forward secrecy only holds across reseed boundaries: the current state can
+// not be used to reconstruct output generated before the most recent rekey,
+// but it can reconstruct all of the output generated since then (up to
+// reseedInterval bytes).  Callers that need every Read to be protected
+// should use a correspondingly small reseedInterval.
+func NewCSPRNG(key []byte, reseedInterval int) (io.Reader, error) {
+	if reseedInterval <= 0 {
+		return nil, ErrInvalidReseedInterval
+	}
+
+	r := &csprng{
+		interval: reseedInterval,
+	}
+	if err := r.setKey(key); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Shuffle pseudo-randomizes the order of n elements with a Fisher-Yates
+// shuffle driven by the key stream.  swap swaps the elements with indexes
+// i and j.  It panics if n < 0.
+//
+// For reproducibility, the key stream is consumed as follows: for each i
+// from n-1 down to 1, 8 byte little endian values v are read until
+// v >= (2^64 - (i+1)) % (i+1), and then swap(i, v % (i+1)) is called.
+func (c *Cipher) Shuffle(n int, swap func(i, j int)) {
+	if n < 0 {
+		panic("chacha20: invalid argument to Shuffle")
+	}
+
+	var tmp [8]byte
+	for i := n - 1; i > 0; i-- {
+		bound := uint64(i) + 1
+		threshold := -bound % bound
+		for {
+			c.KeyStream(tmp[:])
+			if v := binary.LittleEndian.Uint64(tmp[:]); v >= threshold {
+				swap(i, int(v%bound))
+				break
+			}
+		}
+	}
+	for i := range tmp {
+		tmp[i] = 0
+	}
+}
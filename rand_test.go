@@ -0,0 +1,135 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandSource(t *testing.T) {
+	require := require.New(t)
+
+	// The all zero key and nonce matches IETF Draft TC1.
+	var seed [KeySize]byte
+	v := draftTestVectors[0]
+	require.Equal(seed[:], v.key, "TC1 key")
+
+	src := NewRandSource(seed)
+	for i := 0; i < len(v.stream)/8; i++ {
+		expected := binary.LittleEndian.Uint64(v.stream[i*8:])
+		require.Equalf(expected, src.Uint64(), "Uint64(): %d", i)
+	}
+
+	src = NewRandSource(seed)
+	for i := 0; i < len(v.stream)/8; i++ {
+		expected := int64(binary.LittleEndian.Uint64(v.stream[i*8:]) &^ (1 << 63))
+		require.Equalf(expected, src.Int63(), "Int63(): %d", i)
+	}
+
+	src.Seed(0)
+	require.Equal(binary.LittleEndian.Uint64(v.stream[0:]), src.Uint64(), "Seed(0)")
+}
+
+func TestCSPRNG(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+	)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	_, err := NewCSPRNG(key[:], 0)
+	require.Equal(ErrInvalidReseedInterval, err, "NewCSPRNG(key, 0)")
+	_, err = NewCSPRNG(key[:16], 64)
+	require.Equal(ErrInvalidKey, err, "NewCSPRNG(short key, 64)")
+
+	const interval = 100
+
+	rng, err := NewCSPRNG(key[:], interval)
+	require.NoError(err, "NewCSPRNG")
+
+	var out [3 * interval]byte
+	for i, sz := 0, 1; i < len(out); sz++ {
+		// Read in varying sized chunks to exercise the reseed boundary.
+		if i+sz > len(out) {
+			sz = len(out) - i
+		}
+		n, rdErr := rng.Read(out[i : i+sz])
+		require.NoError(rdErr, "Read")
+		require.Equal(sz, n, "Read: length")
+		i += sz
+	}
+
+	// Prior to the first reseed, the output is the raw keystream.
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+	var stream [2 * interval]byte
+	c.KeyStream(stream[:])
+	require.Equal(stream[:interval], out[:interval], "Output before reseed")
+	require.NotEqual(stream[interval:], out[interval:2*interval], "Output after reseed")
+
+	// The next KeySize bytes of keystream become the new key.
+	c, err = New(stream[interval:interval+KeySize], nonce[:])
+	require.NoError(err, "New - reseeded")
+	c.KeyStream(stream[:])
+	require.Equal(stream[:interval], out[interval:2*interval], "Output after reseed")
+}
+
+func TestShuffle(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	require.NoError(err, "New")
+
+	p := make([]int, 16)
+	for i := range p {
+		p[i] = i
+	}
+	c.Shuffle(len(p), func(i, j int) {
+		p[i], p[j] = p[j], p[i]
+	})
+
+	// The first swap uses 0x903df1a0ade0b876 % 16 = 6.
+	expected := []int{2, 4, 15, 1, 5, 11, 9, 7, 12, 0, 3, 10, 14, 13, 8, 6}
+	require.Equal(expected, p, "Shuffle - fixed permutation")
+
+	// n <= 1 consumes no key stream.
+	c, err = New(key[:], nonce[:])
+	require.NoError(err, "New")
+	for _, n := range []int{0, 1} {
+		c.Shuffle(n, func(i, j int) {
+			t.Fatalf("swap called for n = %d", n)
+		})
+	}
+	var b [1]byte
+	c.KeyStream(b[:])
+	require.EqualValues(0x76, b[0], "KeyStream - after Shuffle(0), Shuffle(1)")
+
+	require.Panics(func() {
+		c.Shuffle(-1, func(i, j int) {})
+	}, "Shuffle - negative n")
+}
@@ -0,0 +1,88 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrSelfTestFailed is the error returned when the known answer self-test
+// fails.
+var ErrSelfTestFailed = errors.New("chacha20: self-test failed")
+
+var (
+	// selfTestBlock is block 0 of the all zero key and nonce, from
+	// draft-strombergson-chacha-test-vectors-01 TC1.
+	selfTestBlock = []byte{
+		0x76, 0xb8, 0xe0, 0xad, 0xa0, 0xf1, 0x3d, 0x90,
+		0x40, 0x5d, 0x6a, 0xe5, 0x53, 0x86, 0xbd, 0x28,
+		0xbd, 0xd2, 0x19, 0xb8, 0xa0, 0x8d, 0xed, 0x1a,
+		0xa8, 0x36, 0xef, 0xcc, 0x8b, 0x77, 0x0d, 0xc7,
+		0xda, 0x41, 0x59, 0x7c, 0x51, 0x57, 0x48, 0x8d,
+		0x77, 0x24, 0xe0, 0x3f, 0xb8, 0xd8, 0x4a, 0x37,
+		0x6a, 0x43, 0xb8, 0xf4, 0x15, 0x18, 0xa1, 0x1c,
+		0xc3, 0x87, 0xb6, 0x69, 0xb2, 0xee, 0x65, 0x86,
+	}
+
+	// selfTestDigest is the SHA-512/256 digest of the key stream for the
+	// all zero key and nonce, generated in calls of 1 through 2048 bytes.
+	selfTestDigest = []byte{
+		0xcf, 0xd6, 0xe9, 0x49, 0x22, 0x5b, 0x85, 0x4f,
+		0xe0, 0x49, 0x46, 0x49, 0x1e, 0x69, 0x35, 0xff,
+		0x05, 0xff, 0x98, 0x3d, 0x15, 0x54, 0xbc, 0x88,
+		0x5b, 0xca, 0x0e, 0xc8, 0x08, 0x2d, 0xd5, 0xb8,
+	}
+)
+
+// SelfTest runs a known answer test against the active implementation, and
+// returns ErrSelfTestFailed if the output is incorrect (eg: due to a
+// miscompiled or corrupted backend).  Building with the chacha20_selftest
+// tag runs it automatically at initialization, and panics on failure.
+func SelfTest() error {
+	var (
+		key   [KeySize]byte
+		nonce [NonceSize]byte
+
+		buf [2048]byte
+	)
+
+	c, err := New(key[:], nonce[:])
+	if err != nil {
+		return err
+	}
+	defer c.Reset()
+
+	c.XORKeyStream(buf[:len(selfTestBlock)], buf[:len(selfTestBlock)])
+	if subtle.ConstantTimeCompare(buf[:len(selfTestBlock)], selfTestBlock) != 1 {
+		return ErrSelfTestFailed
+	}
+
+	if err = c.Seek(0); err != nil {
+		return err
+	}
+	h := sha512.New512_256()
+	for i := 1; i <= len(buf); i++ {
+		c.KeyStream(buf[:i])
+		_, _ = h.Write(buf[:i])
+	}
+	if subtle.ConstantTimeCompare(h.Sum(nil), selfTestDigest) != 1 {
+		return ErrSelfTestFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build chacha20_selftest
+// +build chacha20_selftest
+
+package chacha20
+
+func init() {
+	// This runs after the implementation selection in chacha20.go, as
+	// files are initialized in the order they are presented to the
+	// compiler.
+	if err := SelfTest(); err != nil {
+		panic(err)
+	}
+}
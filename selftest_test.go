@@ -0,0 +1,53 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+// brokenImpl is an implementation that corrupts the last block of output.
+type brokenImpl struct {
+	api.Implementation
+}
+
+func (impl *brokenImpl) Blocks(x *[api.StateSize]uint32, dst, src []byte, nrBlocks int) {
+	impl.Implementation.Blocks(x, dst, src, nrBlocks)
+	dst[nrBlocks*api.BlockSize-1] ^= 0x01
+}
+
+func TestSelfTest(t *testing.T) {
+	oldImpl := activeImpl
+	defer func() {
+		activeImpl = oldImpl
+	}()
+
+	for _, v := range supportedImpls {
+		t.Run(v.Name(), func(t *testing.T) {
+			require := require.New(t)
+
+			activeImpl = v
+			require.NoError(SelfTest(), "SelfTest")
+
+			activeImpl = &brokenImpl{v}
+			require.Equal(ErrSelfTestFailed, SelfTest(), "SelfTest - broken")
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+var (
+	// ErrInvalidSnapshot is the error returned when a snapshot is malformed.
+	ErrInvalidSnapshot = errors.New("chacha20: invalid snapshot")
+
+	// ErrSnapshotUnsupported is the error returned when snapshotting an
+	// instance that is not keyed with the caller's key (a SubStream of an
+	// XChaCha20 instance).
+	ErrSnapshotUnsupported = errors.New("chacha20: snapshot of an XChaCha20 SubStream is not supported")
+
+	_ encoding.BinaryMarshaler   = (*Cipher)(nil)
+	_ encoding.BinaryUnmarshaler = (*Cipher)(nil)
+)
+
+// Snapshot serializes the resumable state of the instance (the variant,
+// nonce, and key stream position) into a byte slice, suitable for passing
+// to RestoreCipher.  The snapshot does not contain the key.
+//
+// Snapshot layout: mode (1 byte) || nonce || block counter (8 bytes, little
+// endian) || offset into the block (1 byte).
+//
+// As the snapshot is restored with the caller's key, ErrSnapshotUnsupported
+// is returned for a SubStream of an XChaCha20 instance, which is keyed with
+// the HChaCha20 derived subkey.
+func (c *Cipher) Snapshot() ([]byte, error) {
+	if c.subKeyed {
+		return nil, ErrSnapshotUnsupported
+	}
+	nonceSize := c.mode.nonceSize()
+	if nonceSize == 0 {
+		return nil, ErrInvalidNonce
+	}
+
+	b := make([]byte, 0, 1+nonceSize+8+1)
+	b = append(b, byte(c.mode))
+	b = append(b, c.nonce[:nonceSize]...)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[1+nonceSize:], c.counter())
+	b = append(b, byte(c.off))
+
+	return b, nil
+}
+
+// RestoreCipher returns a new ChaCha20/XChaCha20 instance with the provided
+// key, resuming from a snapshot previously returned by Snapshot.
+func RestoreCipher(key, snapshot []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	_, nonce, ctr, off, err := parseSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cipher
+	if err = c.doReKey(key, nonce); err != nil {
+		return nil, err
+	}
+	c.setPosition(ctr, off)
+
+	return &c, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, and is equivalent to
+// Snapshot.  The key is not included.
+func (c *Cipher) MarshalBinary() ([]byte, error) {
+	return c.Snapshot()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, restoring the
+// variant, nonce, and key stream position from a snapshot.  As the snapshot
+// does not contain the key, any existing key is cleared, and SetKey must be
+// called before the instance is used.
+func (c *Cipher) UnmarshalBinary(data []byte) error {
+	mode, nonce, ctr, off, err := parseSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	c.Reset()
+	c.mode = mode
+	copy(c.nonce[:], nonce)
+	c.state[12] = uint32(ctr)
+	c.state[13] = uint32(ctr >> 32)
+	c.off = off
+
+	return nil
+}
+
+// SetKey sets the key of the instance, retaining the variant, nonce, and
+// key stream position (eg: after UnmarshalBinary).
+func (c *Cipher) SetKey(key []byte) error {
+	if len(key) != KeySize {
+		return ErrInvalidKey
+	}
+
+	var nonce [XNonceSize]byte
+	copy(nonce[:], c.nonce[:])
+	ctr, off := c.counter(), c.off
+
+	c.Reset()
+	if err := c.doReKey(key, nonce[:c.mode.nonceSize()]); err != nil {
+		return err
+	}
+	c.setPosition(ctr, off)
+
+	return nil
+}
+
+// counter returns the block counter.
+func (c *Cipher) counter() uint64 {
+	if c.mode == ModeIETF {
+		return uint64(c.state[12])
+	}
+	return uint64(c.state[12]) | uint64(c.state[13])<<32
+}
+
+// setPosition sets the block counter and offset into the current block,
+// regenerating the buffered key stream if part of a block was consumed.
+func (c *Cipher) setPosition(ctr uint64, off int) {
+	// If part of a block was buffered, regenerate it, which leaves the
+	// block counter at the saved value.
+	if off != api.BlockSize {
+		ctr--
+	}
+	c.state[12] = uint32(ctr)
+	if c.mode != ModeIETF {
+		c.state[13] = uint32(ctr >> 32)
+	}
+	c.off = api.BlockSize
+	if off != api.BlockSize {
+		c.doBlocks(c.buf[:], nil, 1)
+		c.off = off
+	}
+}
+
+func parseSnapshot(b []byte) (Mode, []byte, uint64, int, error) {
+	if len(b) < 1 {
+		return 0, nil, 0, 0, ErrInvalidSnapshot
+	}
+	mode := Mode(b[0])
+	nonceSize := mode.nonceSize()
+	if nonceSize == 0 || len(b) != 1+nonceSize+8+1 {
+		return 0, nil, 0, 0, ErrInvalidSnapshot
+	}
+	nonce := b[1 : 1+nonceSize]
+	ctr := binary.LittleEndian.Uint64(b[1+nonceSize:])
+	off := int(b[len(b)-1])
+	if off > api.BlockSize {
+		return 0, nil, 0, 0, ErrInvalidSnapshot
+	}
+	if mode == ModeIETF && (ctr > math.MaxUint32 || (ctr == 0 && off != api.BlockSize)) {
+		return 0, nil, 0, 0, ErrInvalidSnapshot
+	}
+
+	return mode, nonce, ctr, off, nil
+}
@@ -0,0 +1,189 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key   [KeySize]byte
+		nonce [XNonceSize]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		c, err := New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		require.Equal(nonce[:nonceSize], c.Nonce(), "Nonce")
+
+		for _, pos := range []int{0, 1, 63, 64, 65, 150} {
+			var expected, out [3 * api.BlockSize]byte
+
+			c, err = New(key[:], nonce[:nonceSize])
+			require.NoError(err, "New")
+			c.KeyStream(out[:pos])
+
+			snap, err := c.Snapshot()
+			require.NoError(err, "Snapshot")
+			require.Len(snap, 1+nonceSize+8+1, "Snapshot - length")
+			c.KeyStream(expected[:])
+
+			c, err = RestoreCipher(key[:], snap)
+			require.NoErrorf(err, "RestoreCipher: %d", pos)
+			require.Equal(nonce[:nonceSize], c.Nonce(), "Nonce - restored")
+			c.KeyStream(out[:])
+			require.Equalf(expected, out, "KeyStream - restored: %d", pos)
+		}
+	}
+
+	// 64 bit counter wrap, with a partial block buffered.
+	c, err := NewDJB(key[:], nonce[:NonceSize])
+	require.NoError(err, "NewDJB")
+	err = c.Seek(math.MaxUint64)
+	require.NoError(err, "Seek")
+	var expected, out [api.BlockSize]byte
+	c.KeyStream(out[:3])
+	snap, err := c.Snapshot()
+	require.NoError(err, "Snapshot - wrap")
+	c.KeyStream(expected[:])
+	c, err = RestoreCipher(key[:], snap)
+	require.NoError(err, "RestoreCipher - wrap")
+	c.KeyStream(out[:])
+	require.Equal(expected, out, "KeyStream - restored wrap")
+
+	// Invalid snapshots.
+	c, err = NewIETF(key[:], nonce[:INonceSize])
+	require.NoError(err, "NewIETF")
+	snap, err = c.Snapshot()
+	require.NoError(err, "Snapshot")
+
+	_, err = RestoreCipher(key[:1], snap)
+	require.Equal(ErrInvalidKey, err, "RestoreCipher - invalid key")
+	_, err = RestoreCipher(key[:], nil)
+	require.Equal(ErrInvalidSnapshot, err, "RestoreCipher - empty")
+	_, err = RestoreCipher(key[:], snap[:len(snap)-1])
+	require.Equal(ErrInvalidSnapshot, err, "RestoreCipher - truncated")
+
+	bad := append([]byte{}, snap...)
+	bad[0] = 0xff
+	_, err = RestoreCipher(key[:], bad)
+	require.Equal(ErrInvalidSnapshot, err, "RestoreCipher - mode")
+
+	bad = append([]byte{}, snap...)
+	bad[len(bad)-1] = api.BlockSize + 1
+	_, err = RestoreCipher(key[:], bad)
+	require.Equal(ErrInvalidSnapshot, err, "RestoreCipher - offset")
+
+	bad = append([]byte{}, snap...)
+	bad[1+INonceSize+4] = 1
+	_, err = RestoreCipher(key[:], bad)
+	require.Equal(ErrInvalidSnapshot, err, "RestoreCipher - IETF counter")
+}
+
+func TestMarshalBinary(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key, key2 [KeySize]byte
+		nonce     [XNonceSize]byte
+	)
+	_, err := rand.Read(key[:])
+	require.NoError(err, "rand.Read(key)")
+	_, err = rand.Read(key2[:])
+	require.NoError(err, "rand.Read(key2)")
+	_, err = rand.Read(nonce[:])
+	require.NoError(err, "rand.Read(nonce)")
+
+	for _, nonceSize := range []int{NonceSize, INonceSize, XNonceSize} {
+		for _, pos := range []int{0, 1, 63, 64, 65, 150} {
+			var expected, out [3 * api.BlockSize]byte
+
+			c, err := New(key[:], nonce[:nonceSize])
+			require.NoError(err, "New")
+			c.KeyStream(out[:pos])
+
+			b, err := c.MarshalBinary()
+			require.NoError(err, "MarshalBinary")
+
+			// Round trip via gob, which uses the encoding.Binary* interfaces.
+			var buf bytes.Buffer
+			err = gob.NewEncoder(&buf).Encode(c)
+			require.NoError(err, "gob.Encode")
+			c.KeyStream(expected[:])
+
+			for _, src := range []string{"UnmarshalBinary", "gob"} {
+				var c2 Cipher
+				switch src {
+				case "UnmarshalBinary":
+					err = c2.UnmarshalBinary(b)
+				case "gob":
+					err = gob.NewDecoder(&buf).Decode(&c2)
+				}
+				require.NoErrorf(err, "%s: %d, %d", src, nonceSize, pos)
+				require.Equal(c.Mode(), c2.Mode(), "Mode")
+				require.Equal(nonce[:nonceSize], c2.Nonce(), "Nonce")
+				require.Equal([api.StateSize - 4]uint32{}, [api.StateSize - 4]uint32{
+					c2.state[0], c2.state[1], c2.state[2], c2.state[3],
+					c2.state[4], c2.state[5], c2.state[6], c2.state[7],
+					c2.state[8], c2.state[9], c2.state[10], c2.state[11],
+				}, "state - no key material")
+
+				err = c2.SetKey(key[:])
+				require.NoError(err, "SetKey")
+				c2.KeyStream(out[:])
+				require.Equalf(expected, out, "KeyStream - %s: %d, %d", src, nonceSize, pos)
+			}
+		}
+
+		// SetKey on a keyed instance changes the key, not the position.
+		var expected, out [2 * api.BlockSize]byte
+		c, err := New(key2[:], nonce[:nonceSize])
+		require.NoError(err, "New - key2")
+		c.KeyStream(out[:70])
+		c.KeyStream(expected[:])
+
+		c, err = New(key[:], nonce[:nonceSize])
+		require.NoError(err, "New")
+		c.KeyStream(out[:70])
+		err = c.SetKey(key2[:])
+		require.NoError(err, "SetKey - key2")
+		require.Equal(nonce[:nonceSize], c.Nonce(), "Nonce - after SetKey")
+		c.KeyStream(out[:])
+		require.Equal(expected, out, "KeyStream - after SetKey")
+
+		err = c.SetKey(key[:1])
+		require.Equal(ErrInvalidKey, err, "SetKey - invalid key")
+	}
+
+	var c Cipher
+	err = c.UnmarshalBinary([]byte{0xff})
+	require.Equal(ErrInvalidSnapshot, err, "UnmarshalBinary - invalid")
+}
@@ -0,0 +1,62 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+// Key is a ChaCha20 key.
+type Key [KeySize]byte
+
+// NonceDJB is an original ChaCha20 64 bit nonce.
+type NonceDJB [NonceSize]byte
+
+// NonceIETF is an IETF ChaCha20 96 bit nonce.
+type NonceIETF [INonceSize]byte
+
+// NonceX is an XChaCha20 192 bit nonce.
+type NonceX [XNonceSize]byte
+
+// NewTypedDJB returns a new ChaCha20 instance with the original 64 bit nonce
+// and 64 bit block counter.  Unlike NewDJB, the key and nonce sizes are
+// checked at compile time, so it can not fail.
+func NewTypedDJB(key Key, nonce NonceDJB) *Cipher {
+	return newTyped(key[:], nonce[:])
+}
+
+// NewTypedIETF returns a new ChaCha20 instance with the IETF 96 bit nonce and
+// 32 bit block counter.  Unlike NewIETF, the key and nonce sizes are checked
+// at compile time, so it can not fail.
+func NewTypedIETF(key Key, nonce NonceIETF) *Cipher {
+	return newTyped(key[:], nonce[:])
+}
+
+// NewTypedX returns a new XChaCha20 instance with a 192 bit nonce and 64 bit
+// block counter.  Unlike NewX, the key and nonce sizes are checked at compile
+// time, so it can not fail.
+func NewTypedX(key Key, nonce NonceX) *Cipher {
+	return newTyped(key[:], nonce[:])
+}
+
+func newTyped(key, nonce []byte) *Cipher {
+	var c Cipher
+	if err := c.doReKey(key, nonce); err != nil {
+		// Not possible, the sizes are enforced by the types.
+		panic("chacha20: BUG: " + err.Error())
+	}
+	for i := range key {
+		key[i] = 0
+	}
+
+	return &c
+}
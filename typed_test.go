@@ -0,0 +1,63 @@
+// Copryright (C) 2019 Yawning Angel
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chacha20
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fengxuway/chacha20/internal/api"
+)
+
+func TestTyped(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		key    Key
+		nonce  NonceDJB
+		iNonce NonceIETF
+		xNonce NonceX
+	)
+	for _, b := range [][]byte{key[:], nonce[:], iNonce[:], xNonce[:]} {
+		_, err := rand.Read(b)
+		require.NoError(err, "rand.Read")
+	}
+
+	for _, v := range []struct {
+		name  string
+		typed *Cipher
+		nonce []byte
+	}{
+		{"DJB", NewTypedDJB(key, nonce), nonce[:]},
+		{"IETF", NewTypedIETF(key, iNonce), iNonce[:]},
+		{"X", NewTypedX(key, xNonce), xNonce[:]},
+	} {
+		var expected, out [2*api.BlockSize + 3]byte
+
+		c, err := New(key[:], v.nonce)
+		require.NoErrorf(err, "New: %s", v.name)
+		c.KeyStream(expected[:])
+
+		require.Equalf(c.Mode(), v.typed.Mode(), "Mode: %s", v.name)
+		v.typed.KeyStream(out[:])
+		require.Equalf(expected, out, "KeyStream: %s", v.name)
+	}
+
+	// The caller's key is passed by value, and left intact.
+	require.NotEqual(Key{}, key, "Key - not cleared")
+}